@@ -6,10 +6,17 @@ import (
 	"github.com/IakimenkoD/xm-companies-service/internal/api"
 	"github.com/IakimenkoD/xm-companies-service/internal/config"
 	"github.com/IakimenkoD/xm-companies-service/internal/controller"
+	ierr "github.com/IakimenkoD/xm-companies-service/internal/errors"
+	"github.com/IakimenkoD/xm-companies-service/internal/metrics"
+	"github.com/IakimenkoD/xm-companies-service/internal/model"
 	"github.com/IakimenkoD/xm-companies-service/internal/repository/database"
 	"github.com/IakimenkoD/xm-companies-service/internal/repository/dataprovider/pg"
 	"github.com/IakimenkoD/xm-companies-service/internal/service"
+	"github.com/IakimenkoD/xm-companies-service/internal/service/auth"
+	"github.com/IakimenkoD/xm-companies-service/internal/service/geoip"
 	"github.com/IakimenkoD/xm-companies-service/internal/service/http"
+	"github.com/IakimenkoD/xm-companies-service/internal/tracing"
+	"github.com/pkg/errors"
 	"go.uber.org/zap"
 	"os"
 	"os/signal"
@@ -26,6 +33,18 @@ func main() {
 		logger.Fatal("can't init config")
 	}
 
+	shutdownTracing, err := tracing.Init(context.Background(), cfg.Tracing)
+	if err != nil {
+		logger.Fatal("can't init tracing", zap.Error(err))
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error("flushing spans", zap.Error(err))
+		}
+	}()
+
 	dbClient, err := database.NewClient(cfg)
 	if err != nil {
 		logger.Fatal("can't establish database connection", zap.Error(err))
@@ -34,21 +53,83 @@ func main() {
 		logger.Fatal("while applying database migration", zap.Error(err))
 	}
 	logger.Info("db migration successful")
+	metrics.RegisterDB(dbClient.DB.DB)
+
+	// background workers are stopped on exit
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
 
-	mq, err := service.NewMessageQueue(cfg, logger)
+	mq := service.NewMessageQueue(workersCtx, cfg, logger)
+
+	storage := pg.NewCompanyStorage(dbClient, logger)
+	outboxStorage := pg.NewOutboxStorage(dbClient, logger)
+	revisionStorage := pg.NewRevisionStorage(dbClient, logger)
+	companiesService := controller.NewCompaniesService(cfg, storage, outboxStorage, revisionStorage, dbClient)
+	userStorage := pg.NewUserStorage(dbClient, logger)
+	tokenStorage := pg.NewTokenStorage(dbClient, logger)
+	usersService := controller.NewUsersService(cfg, userStorage, tokenStorage, dbClient)
+	sessionsService := controller.NewSessionsService(cfg, userStorage, tokenStorage, dbClient)
+	if cfg.API.AdminPassword != "" {
+		admin := &model.User{Username: cfg.API.AdminUsername, Role: model.RoleAdmin}
+		if err = usersService.EnsureUser(context.Background(), admin, cfg.API.AdminPassword); err != nil {
+			logger.Fatal("can't create admin user", zap.Error(err))
+		}
+	}
+
+	keys, err := auth.NewKeySet(cfg, logger)
 	if err != nil {
-		logger.Fatal("while message queue init", zap.Error(err))
+		logger.Fatal("can't load jwt keys", zap.Error(err))
+	}
+	go keys.Run(workersCtx)
+
+	authenticator := auth.NewJWTAuthenticator(keys, sessionsService)
+	switch cfg.API.AuthMode {
+	case config.AuthModeJWT:
+	case config.AuthModeStatic:
+		switch {
+		case !auth.StaticAuthCompiled:
+			logger.Fatal("static auth mode is not available in production build")
+		case cfg.Environment != config.EnvironmentDevelopment && cfg.Environment != config.EnvironmentTest:
+			logger.Fatal("static auth mode is allowed in development and test environments only",
+				zap.String("environment", cfg.Environment))
+		}
+		logger.Warn("static auth mode enabled, predefined tokens are accepted")
+		authenticator = auth.NewStaticAuthenticator(cfg.API.StaticTokens, authenticator)
+	default:
+		logger.Fatal("unknown auth mode", zap.String("auth_mode", cfg.API.AuthMode))
 	}
 
-	storage := pg.NewCompanyStorage(dbClient, logger)
-	companiesService := controller.NewCompaniesService(cfg, storage, mq)
-	ipChecker := http.NewIpChecker(cfg, logger)
+	ipChecker, err := newIpChecker(cfg, logger)
+	if err != nil {
+		logger.Fatal("can't init location providers", zap.Error(err))
+	}
+
+	checks := []api.HealthCheck{
+		{Name: "postgres", Critical: true, Check: dbClient.StatusCheck},
+		// events wait in outbox while the broker is down, so requests are still served
+		{Name: "rabbitmq", Check: mq.StatusCheck},
+	}
+	if cfg.Health.LocationIP != "" {
+		// providers are checked bypassing location cache, but not more often than location interval
+		checks = append(checks, api.HealthCheck{Name: "location", Check: api.CachedCheck(cfg.Health.LocationInterval,
+			func(ctx context.Context) error {
+				_, err := ipChecker.GetUserLocation(ctx, cfg.Health.LocationIP)
+				if errors.Is(err, ierr.UnknownLocation) {
+					return nil
+				}
+				return err
+			})})
+	}
 
-	apiServer, err := api.NewServer(cfg, companiesService, ipChecker)
+	apiServer, err := api.NewServer(cfg, companiesService, usersService, sessionsService, keys, authenticator,
+		newCachedIpChecker(cfg, ipChecker), checks...)
 	if err != nil {
 		logger.Fatal("server init failed", zap.Error(err))
 	}
 
+	go controller.NewOutboxRelay(cfg, outboxStorage, dbClient, mq, logger).Run(workersCtx)
+	go controller.NewTrashPurger(cfg, storage, logger).Run(workersCtx)
+
 	shutdown := make(chan os.Signal, 1)
 	serverErrors := make(chan error, 1)
 
@@ -81,3 +162,48 @@ func main() {
 		}
 	}
 }
+
+func newIpChecker(cfg *config.Config, logger *zap.Logger) (service.IpChecker, error) {
+	providers := make([]service.IpChecker, 0, len(cfg.IpApi.Providers))
+	for _, name := range cfg.IpApi.Providers {
+		switch name {
+		case config.IpProviderIpApi:
+			providers = append(providers, service.NewMeasuredIpChecker(name, http.NewIpChecker(cfg, logger)))
+		case config.IpProviderMMDB:
+			p, err := geoip.NewMMDBIpChecker(cfg.IpApi.MMDBPath)
+			if err != nil {
+				return nil, err
+			}
+			providers = append(providers, service.NewMeasuredIpChecker(name, p))
+		case config.IpProviderCSV:
+			p, err := geoip.NewCSVIpChecker(cfg.IpApi.CSVPath)
+			if err != nil {
+				return nil, err
+			}
+			providers = append(providers, service.NewMeasuredIpChecker(name, p))
+		default:
+			return nil, errors.Errorf("unknown location provider %q", name)
+		}
+	}
+
+	switch len(providers) {
+	case 0:
+		return nil, errors.New("no location providers configured")
+	case 1:
+		return providers[0], nil
+	default:
+		return service.NewIpCheckerChain(logger, providers...), nil
+	}
+}
+
+func newCachedIpChecker(cfg *config.Config, ipChecker service.IpChecker) service.IpChecker {
+	if cfg.IpApi.CacheSize > 0 {
+		cache := service.NewCachedIpChecker(ipChecker, cfg.IpApi.CacheSize, cfg.IpApi.CacheTTL, cfg.IpApi.CacheNegativeTTL)
+		metrics.RegisterLocationCache(func() (hits, negativeHits, misses uint64) {
+			stats := cache.Stats()
+			return stats.Hits, stats.NegativeHits, stats.Misses
+		})
+		ipChecker = cache
+	}
+	return ipChecker
+}
@@ -2,15 +2,24 @@ package api
 
 import (
 	"encoding/json"
+	ierr "github.com/IakimenkoD/xm-companies-service/internal/errors"
 	"github.com/IakimenkoD/xm-companies-service/internal/model"
-	"github.com/dgrijalva/jwt-go"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
 	"net/http"
 	"time"
 )
 
-var users = map[string]string{
-	"user1": "password1",
-	"user2": "password2",
+const (
+	accessTokenCookie  = "token"
+	refreshTokenCookie = "refresh_token"
+)
+
+type tokensResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
 }
 
 func (srv *Server) signIn(w http.ResponseWriter, r *http.Request) {
@@ -25,31 +34,125 @@ func (srv *Server) signIn(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	expectedPassword, ok := users[creds.Login]
+	user, err := srv.users.SignIn(r.Context(), creds.Login, creds.Password)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	refreshToken, err := srv.sessions.CreateSession(r.Context(), user)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	srv.respondTokens(w, user, refreshToken)
+}
+
+func (srv *Server) jwks(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := json.NewEncoder(w).Encode(srv.signer.JWKS()); err != nil {
+		respondError(w, err)
+		return
+	}
+}
 
-	if !ok || expectedPassword != creds.Password {
-		w.WriteHeader(http.StatusUnauthorized)
+func (srv *Server) refresh(w http.ResponseWriter, r *http.Request) {
+	refreshToken, err := getRefreshToken(r)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	user, refreshToken, err := srv.sessions.Refresh(r.Context(), refreshToken)
+	if err != nil {
+		respondError(w, err)
 		return
 	}
 
-	expirationTime := time.Now().Add(256 * time.Minute)
+	srv.respondTokens(w, user, refreshToken)
+}
+
+// signOut revokes access token of the request and refresh token if it's passed.
+func (srv *Server) signOut(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	claims := model.ClaimsFromContext(ctx)
+	if claims != nil && claims.Id != "" {
+		if err := srv.sessions.RevokeAccessToken(ctx, claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+			respondError(w, err)
+			return
+		}
+	}
+
+	if refreshToken, err := getRefreshToken(r); err == nil {
+		if err = srv.sessions.RevokeSession(ctx, refreshToken); err != nil {
+			respondError(w, err)
+			return
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: accessTokenCookie, MaxAge: -1})
+	http.SetCookie(w, &http.Cookie{Name: refreshTokenCookie, Path: "/internal", MaxAge: -1})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (srv *Server) respondTokens(w http.ResponseWriter, user *model.User, refreshToken string) {
+	expirationTime := time.Now().Add(srv.cfg.API.AccessTokenTTL)
 	claims := &model.Claims{
-		Username: creds.Login,
+		Username: user.Username,
+		Role:     user.Role,
+		Scopes:   model.RoleScopes(user.Role),
 		StandardClaims: jwt.StandardClaims{
+			Id:        model.NewUUID(),
 			ExpiresAt: expirationTime.Unix(),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(srv.cfg.API.JWTKey)
+	tokenString, err := srv.signer.Sign(claims)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 	http.SetCookie(w, &http.Cookie{
-		Name:    "token",
+		Name:    accessTokenCookie,
 		Value:   tokenString,
 		Expires: expirationTime,
 	})
-	w.Write([]byte(tokenString))
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshTokenCookie,
+		Value:    refreshToken,
+		Path:     "/internal",
+		Expires:  time.Now().Add(srv.cfg.API.RefreshTokenTTL),
+		HttpOnly: true,
+	})
+
+	resp := tokensResponse{
+		AccessToken:  tokenString,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(srv.cfg.API.AccessTokenTTL.Seconds()),
+	}
+	if err = json.NewEncoder(w).Encode(resp); err != nil {
+		respondError(w, err)
+		return
+	}
+}
+
+// getRefreshToken gets refresh token either from request body or cookie.
+func getRefreshToken(r *http.Request) (string, error) {
+	req := struct {
+		RefreshToken string `json:"refresh_token"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err == nil && req.RefreshToken != "" {
+		return req.RefreshToken, nil
+	}
+
+	ck, err := r.Cookie(refreshTokenCookie)
+	if err != nil || ck.Value == "" {
+		return "", errors.Wrap(ierr.InvalidParam, "refresh_token")
+	}
+
+	return ck.Value, nil
 }
@@ -10,8 +10,38 @@ import (
 	"strconv"
 )
 
-func (srv *Server) health(w http.ResponseWriter, _ *http.Request) {
-	w.WriteHeader(http.StatusOK)
+type companiesResponse struct {
+	Companies  []*model.Company `json:"companies"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+type historyResponse struct {
+	Revisions  []*model.CompanyRevision `json:"revisions"`
+	NextCursor string                   `json:"next_cursor,omitempty"`
+}
+
+// batchRequest changes companies atomically unless Atomic is false, companies being
+// deleted need ID and optional Version only.
+type batchRequest struct {
+	Companies []*model.Company `json:"companies"`
+	Atomic    *bool            `json:"atomic"`
+}
+
+func (req *batchRequest) atomic() bool {
+	return req.Atomic == nil || *req.Atomic
+}
+
+type batchResponse struct {
+	Results []batchResult `json:"results"`
+}
+
+// batchResult is a result of the company at Index of batch request, Status is HTTP status of
+// the same single company request.
+type batchResult struct {
+	Index  int    `json:"index"`
+	ID     int64  `json:"id,omitempty"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
 }
 
 func (srv *Server) getCompanies(w http.ResponseWriter, r *http.Request) {
@@ -22,13 +52,20 @@ func (srv *Server) getCompanies(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	companies, err := srv.controller.GetCompanies(ctx, filter)
+	companies, next, err := srv.controller.GetCompanies(ctx, filter)
 	if err != nil {
 		respondError(w, err)
 		return
 	}
 
-	if err = json.NewEncoder(w).Encode(companies); err != nil {
+	resp := companiesResponse{
+		Companies: companies,
+	}
+	if next != nil {
+		resp.NextCursor = next.Encode()
+	}
+
+	if err = json.NewEncoder(w).Encode(resp); err != nil {
 		respondError(w, err)
 		return
 	}
@@ -44,11 +81,14 @@ func (srv *Server) getCompanyByID(w http.ResponseWriter, r *http.Request) {
 	}
 
 	filter := dataprovider.NewCompanyFilter().ByIDs(id)
-	company, err := srv.controller.GetCompanies(ctx, filter)
+	company, _, err := srv.controller.GetCompanies(ctx, filter)
 	if err != nil {
 		respondError(w, err)
 		return
 	}
+	if len(company) == 1 {
+		w.Header().Set("ETag", etag(company[0]))
+	}
 
 	if err = json.NewEncoder(w).Encode(company); err != nil {
 		respondError(w, err)
@@ -98,15 +138,21 @@ func (srv *Server) updateCompany(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	company.ID = id
+	if company.Version, err = srv.getIfMatch(r); err != nil {
+		respondError(w, err)
+		return
+	}
 
 	if err = srv.controller.UpdateCompany(ctx, company); err != nil {
 		respondError(w, err)
 		return
 	}
 
+	w.Header().Set("ETag", etag(company))
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// patchCompany applies JSON Merge Patch or JSON Patch, plain JSON is treated as merge patch.
 func (srv *Server) patchCompany(w http.ResponseWriter, r *http.Request) {
 	id, err := getURLInt64(r, "companyID")
 	if err != nil {
@@ -115,19 +161,25 @@ func (srv *Server) patchCompany(w http.ResponseWriter, r *http.Request) {
 	}
 
 	ctx := r.Context()
-	company := &model.Company{}
-	if err = json.NewDecoder(r.Body).Decode(company); err != nil {
+	patch, err := decodePatch(r)
+	if err != nil {
 		respondError(w, err)
 		return
 	}
-	company.ID = id
 
-	updated, err := srv.controller.PatchCompany(ctx, company)
+	version, err := srv.getIfMatch(r)
 	if err != nil {
 		respondError(w, err)
 		return
 	}
 
+	updated, err := srv.controller.PatchCompany(ctx, id, version, patch)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	w.Header().Set("ETag", etag(updated))
+
 	if err = json.NewEncoder(w).Encode(updated); err != nil {
 		respondError(w, err)
 		return
@@ -142,9 +194,111 @@ func (srv *Server) deleteCompany(w http.ResponseWriter, r *http.Request) {
 		respondError(w, err)
 		return
 	}
-	if err = srv.controller.DeleteCompany(ctx, id); err != nil {
+	version, err := srv.getIfMatch(r)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	if err = srv.controller.DeleteCompany(ctx, id, version); err != nil {
+		respondError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (srv *Server) restoreCompany(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := getURLInt64(r, "companyID")
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	if err = srv.controller.RestoreCompany(ctx, id); err != nil {
 		respondError(w, err)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
+
+func (srv *Server) getCompanyHistory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	id, err := getURLInt64(r, "companyID")
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	filter, err := parseRevisionsFilter(r)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	revisions, next, err := srv.controller.GetCompanyHistory(ctx, id, filter)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	resp := historyResponse{
+		Revisions: revisions,
+	}
+	if next != nil {
+		resp.NextCursor = next.Encode()
+	}
+
+	if err = json.NewEncoder(w).Encode(resp); err != nil {
+		respondError(w, err)
+		return
+	}
+}
+
+func (srv *Server) batchCreateCompanies(w http.ResponseWriter, r *http.Request) {
+	req, err := srv.decodeBatch(r)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	errs, err := srv.controller.BatchCreateCompanies(r.Context(), req.Companies, req.atomic())
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respondBatch(w, req, errs, http.StatusCreated)
+}
+
+func (srv *Server) batchUpdateCompanies(w http.ResponseWriter, r *http.Request) {
+	req, err := srv.decodeBatch(r)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	errs, err := srv.controller.BatchUpdateCompanies(r.Context(), req.Companies, req.atomic())
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respondBatch(w, req, errs, http.StatusNoContent)
+}
+
+func (srv *Server) batchDeleteCompanies(w http.ResponseWriter, r *http.Request) {
+	req, err := srv.decodeBatch(r)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	errs, err := srv.controller.BatchDeleteCompanies(r.Context(), req.Companies, req.atomic())
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	respondBatch(w, req, errs, http.StatusNoContent)
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"bytes"
 	"context"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"github.com/IakimenkoD/xm-companies-service/internal/config"
@@ -13,13 +14,16 @@ import (
 	"github.com/IakimenkoD/xm-companies-service/internal/repository/dataprovider"
 	"github.com/IakimenkoD/xm-companies-service/internal/repository/dataprovider/pg"
 	"github.com/IakimenkoD/xm-companies-service/internal/service"
+	"github.com/IakimenkoD/xm-companies-service/internal/service/auth"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 )
@@ -27,15 +31,26 @@ import (
 const (
 	cyLocation = "1.1.1.1"
 	usLocation = "8.8.8.8"
-	bsLocation = "10.10.10.10."
+	bsLocation = "10.10.10.10"
+	// officeLocation is unknown to location provider, but belongs to allowed network
+	officeLocation = "9.9.9.9"
+	officeNetwork  = "9.9.9.0/24"
+	// guestLocation belongs to allowed network too, but its part is denied
+	guestLocation = "9.9.9.200"
+	guestNetwork  = "9.9.9.128/25"
 
-	testingToken = "dGVzdCBjYXNlIHJlcXVpcmVkIHRva2Vu"
+	viewerToken = "viewer-token"
+	userToken   = "user-token"
+	adminToken  = "admin-token"
 
-	companiesURL = "/companies"
+	companiesURL = "/api/v1/companies"
+	signInURL    = "/internal/signin"
+	signOutURL   = "/internal/signout"
+	refreshURL   = "/internal/refresh"
+	usersURL     = "/internal/users"
 )
 
 func TestCreateCompanies(t *testing.T) {
-
 	tt := []testCase{
 		{
 			name:           "fail: auth required",
@@ -44,11 +59,20 @@ func TestCreateCompanies(t *testing.T) {
 			prepareRequest: prepareRequest(`{"name": "my company","code": "1235","country": "CY","website": "example.com","phone": "+79991123123"}`, cyLocation),
 			expectedStatus: http.StatusUnauthorized,
 		},
+		{
+			name:           "fail: write permission required",
+			path:           companiesURL,
+			method:         http.MethodPost,
+			token:          viewerToken,
+			prepareRequest: prepareRequest(`{"name": "my company","code": "1235","country": "CY","website": "example.com","phone": "+79991123123"}`, cyLocation),
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   "permission denied\n",
+		},
 		{
 			name:           "fail: all fields required",
 			path:           companiesURL,
 			method:         http.MethodPost,
-			token:          testingToken,
+			token:          userToken,
 			prepareRequest: prepareRequest(`{"name": "my company","code": "1235","website": "example.com","phone": "+79991123123"}`, cyLocation),
 			expectedStatus: http.StatusBadRequest,
 			expectedBody:   "country: Invalid param\n",
@@ -57,7 +81,7 @@ func TestCreateCompanies(t *testing.T) {
 			name:           "fail: wrong location",
 			path:           companiesURL,
 			method:         http.MethodPost,
-			token:          testingToken,
+			token:          userToken,
 			prepareRequest: prepareRequest(`{"name": "my company","code": "1235","website": "example.com","phone": "+79991123123"}`, usLocation),
 			expectedStatus: http.StatusForbidden,
 			expectedBody:   "your location is not allowed\n",
@@ -66,18 +90,69 @@ func TestCreateCompanies(t *testing.T) {
 			name:           "fail: invalid location",
 			path:           companiesURL,
 			method:         http.MethodPost,
-			token:          testingToken,
+			token:          userToken,
 			prepareRequest: prepareRequest(`{"name": "my company","code": "1235","website": "example.com","phone": "+79991123123"}`, bsLocation),
 			expectedStatus: http.StatusInternalServerError,
 			expectedBody:   "unexpected response from external service\n",
 		},
+		{
+			name:   "fail: spoofed forwarded address",
+			path:   companiesURL,
+			method: http.MethodPost,
+			token:  userToken,
+			prepareRequest: func(r *http.Request) {
+				prepareRequest(`{"name": "my company","code": "1235","country": "CY","website": "example.com","phone": "+79991123123"}`, "")(r)
+				r.Header.Set("X-Forwarded-For", cyLocation+", "+usLocation)
+			},
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   "your location is not allowed\n",
+		},
+		{
+			name:           "allowed network passes geofence without location lookup",
+			path:           companiesURL,
+			method:         http.MethodPost,
+			token:          userToken,
+			prepareRequest: prepareRequest(`{"name": "my company","code": "1235","website": "example.com","phone": "+79991123123"}`, officeLocation),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "country: Invalid param\n",
+		},
+		{
+			name:           "fail: denied network overrides allowed one",
+			path:           companiesURL,
+			method:         http.MethodPost,
+			token:          userToken,
+			prepareRequest: prepareRequest(`{"name": "my company","code": "1235","country": "CY","website": "example.com","phone": "+79991123123"}`, guestLocation),
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   "your location is not allowed\n",
+		},
 		{
 			name:           "success",
 			path:           companiesURL,
 			method:         http.MethodPost,
-			token:          testingToken,
+			token:          userToken,
 			prepareRequest: prepareRequest(`{"name": "my company","code": "1235" ,"country":"CY","website": "example.com","phone": "+79991123123"}`, cyLocation),
 			expectedStatus: http.StatusCreated,
+			checkDB: func(t *testing.T, stores *store) {
+				var events int
+				err := stores.client.Get(&events, `SELECT count(*) FROM `+stores.client.SchemaName+`.outbox`)
+				assert.NoError(t, err)
+				assert.Equal(t, 1, events)
+			},
+		},
+		{
+			name:           "fail: code is taken",
+			path:           companiesURL,
+			method:         http.MethodPost,
+			token:          userToken,
+			prepareRequest: prepareRequest(`{"name": "other company","code": "1235" ,"country":"CY","website": "example.org"}`, cyLocation),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "Company with same code already exists\n",
+			checkDB: func(t *testing.T, stores *store) {
+				var events int
+				err := stores.client.Get(&events, `SELECT count(*) FROM `+stores.client.SchemaName+`.outbox`)
+				assert.NoError(t, err)
+				assert.Equal(t, 1, events, "nothing is recorded for rejected company")
+			},
 		}}
 	checkTestCases(t, tt)
 }
@@ -98,6 +173,7 @@ func TestGetCompanies(t *testing.T) {
 		{
 			name:           "get by id",
 			path:           companiesURL + "/14",
+			token:          viewerToken,
 			method:         http.MethodGet,
 			prepareDB:      prepareDB,
 			prepareRequest: prepareRequest(nil, cyLocation),
@@ -112,17 +188,26 @@ func TestGetCompanies(t *testing.T) {
 				}
 			},
 		},
+		{
+			name:           "fail: get without token",
+			path:           companiesURL + "/14",
+			method:         http.MethodGet,
+			prepareRequest: prepareRequest(nil, cyLocation),
+			expectedStatus: http.StatusUnauthorized,
+		},
 		{
 			name:           "get by single id",
 			path:           companiesURL + "?ids=11",
+			token:          viewerToken,
 			method:         http.MethodGet,
 			prepareRequest: prepareRequest(nil, cyLocation),
 			expectedStatus: http.StatusOK,
 			afterTest: func(t *testing.T, resp *http.Response) {
-				var companies []*model.Company
-				if err := json.NewDecoder(resp.Body).Decode(&companies); err != nil {
+				var page companiesResponse
+				if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
 					t.Fatalf("could not decode response body: %+v", err)
 				}
+				companies := page.Companies
 				if assert.Len(t, companies, 1) {
 					assert.Equal(t, "testOne", companies[0].Name)
 				}
@@ -131,14 +216,16 @@ func TestGetCompanies(t *testing.T) {
 		{
 			name:           "get by couple ids",
 			path:           companiesURL + "?ids=11,12",
+			token:          viewerToken,
 			method:         http.MethodGet,
 			prepareRequest: prepareRequest(nil, cyLocation),
 			expectedStatus: http.StatusOK,
 			afterTest: func(t *testing.T, resp *http.Response) {
-				var companies []*model.Company
-				if err := json.NewDecoder(resp.Body).Decode(&companies); err != nil {
+				var page companiesResponse
+				if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
 					t.Fatalf("could not decode response body: %+v", err)
 				}
+				companies := page.Companies
 				if assert.Len(t, companies, 2) {
 					assert.Equal(t, "testOne", companies[0].Name)
 					assert.Equal(t, "testTwo", companies[1].Name)
@@ -148,14 +235,16 @@ func TestGetCompanies(t *testing.T) {
 		{
 			name:           "get by name",
 			path:           companiesURL + "?names=testThree",
+			token:          viewerToken,
 			method:         http.MethodGet,
 			prepareRequest: prepareRequest(nil, cyLocation),
 			expectedStatus: http.StatusOK,
 			afterTest: func(t *testing.T, resp *http.Response) {
-				var companies []*model.Company
-				if err := json.NewDecoder(resp.Body).Decode(&companies); err != nil {
+				var page companiesResponse
+				if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
 					t.Fatalf("could not decode response body: %+v", err)
 				}
+				companies := page.Companies
 				if assert.Len(t, companies, 1) {
 					assert.EqualValues(t, 13, companies[0].ID)
 				}
@@ -164,14 +253,16 @@ func TestGetCompanies(t *testing.T) {
 		{
 			name:           "get by code",
 			path:           companiesURL + "?codes=4444",
+			token:          viewerToken,
 			method:         http.MethodGet,
 			prepareRequest: prepareRequest(nil, cyLocation),
 			expectedStatus: http.StatusOK,
 			afterTest: func(t *testing.T, resp *http.Response) {
-				var companies []*model.Company
-				if err := json.NewDecoder(resp.Body).Decode(&companies); err != nil {
+				var page companiesResponse
+				if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
 					t.Fatalf("could not decode response body: %+v", err)
 				}
+				companies := page.Companies
 				if assert.Len(t, companies, 1) {
 					assert.EqualValues(t, 14, companies[0].ID)
 				}
@@ -180,14 +271,16 @@ func TestGetCompanies(t *testing.T) {
 		{
 			name:           "get by countries, case insensitive",
 			path:           companiesURL + "?countries=cY",
+			token:          viewerToken,
 			method:         http.MethodGet,
 			prepareRequest: prepareRequest(nil, cyLocation),
 			expectedStatus: http.StatusOK,
 			afterTest: func(t *testing.T, resp *http.Response) {
-				var companies []*model.Company
-				if err := json.NewDecoder(resp.Body).Decode(&companies); err != nil {
+				var page companiesResponse
+				if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
 					t.Fatalf("could not decode response body: %+v", err)
 				}
+				companies := page.Companies
 				if assert.Len(t, companies, 2) {
 					assert.EqualValues(t, 11, companies[0].ID)
 					assert.EqualValues(t, 14, companies[1].ID)
@@ -197,14 +290,16 @@ func TestGetCompanies(t *testing.T) {
 		{
 			name:           "get by website",
 			path:           companiesURL + "?websites=testthree.bg",
+			token:          viewerToken,
 			method:         http.MethodGet,
 			prepareRequest: prepareRequest(nil, cyLocation),
 			expectedStatus: http.StatusOK,
 			afterTest: func(t *testing.T, resp *http.Response) {
-				var companies []*model.Company
-				if err := json.NewDecoder(resp.Body).Decode(&companies); err != nil {
+				var page companiesResponse
+				if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
 					t.Fatalf("could not decode response body: %+v", err)
 				}
+				companies := page.Companies
 				if assert.Len(t, companies, 1) {
 					assert.EqualValues(t, 13, companies[0].ID)
 				}
@@ -213,19 +308,263 @@ func TestGetCompanies(t *testing.T) {
 		{
 			name:           "get by phone",
 			path:           companiesURL + "?phones=+004567",
+			token:          viewerToken,
 			method:         http.MethodGet,
 			prepareRequest: prepareRequest(nil, cyLocation),
 			expectedStatus: http.StatusOK,
 			afterTest: func(t *testing.T, resp *http.Response) {
-				var companies []*model.Company
-				if err := json.NewDecoder(resp.Body).Decode(&companies); err != nil {
+				var page companiesResponse
+				if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
 					t.Fatalf("could not decode response body: %+v", err)
 				}
+				companies := page.Companies
 				if assert.Len(t, companies, 1) {
 					assert.EqualValues(t, 14, companies[0].ID)
 				}
 			},
 		},
+		{
+			name:           "sort by country desc, then name",
+			path:           companiesURL + "?sort=-country,name",
+			token:          viewerToken,
+			method:         http.MethodGet,
+			prepareRequest: prepareRequest(nil, cyLocation),
+			expectedStatus: http.StatusOK,
+			afterTest: func(t *testing.T, resp *http.Response) {
+				var page companiesResponse
+				if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+					t.Fatalf("could not decode response body: %+v", err)
+				}
+				companies := page.Companies
+				if assert.Len(t, companies, 4) {
+					assert.EqualValues(t, 12, companies[0].ID)
+					assert.EqualValues(t, 14, companies[1].ID)
+					assert.EqualValues(t, 11, companies[2].ID)
+					assert.EqualValues(t, 13, companies[3].ID)
+				}
+				assert.Empty(t, page.NextCursor)
+			},
+		},
+		{
+			name:           "fail: unknown sort field",
+			path:           companiesURL + "?sort=phone",
+			token:          viewerToken,
+			method:         http.MethodGet,
+			prepareRequest: prepareRequest(nil, cyLocation),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "sort: Invalid param\n",
+		},
+		{
+			name:           "search by name",
+			path:           companiesURL + "?q=TestFour",
+			token:          viewerToken,
+			method:         http.MethodGet,
+			prepareRequest: prepareRequest(nil, cyLocation),
+			expectedStatus: http.StatusOK,
+			afterTest: func(t *testing.T, resp *http.Response) {
+				var page companiesResponse
+				if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+					t.Fatalf("could not decode response body: %+v", err)
+				}
+				if assert.Len(t, page.Companies, 1) {
+					assert.EqualValues(t, 14, page.Companies[0].ID)
+				}
+			},
+		},
+		{
+			name:           "search by name prefix",
+			path:           companiesURL + "?q=testt&match=prefix&sort=id",
+			token:          viewerToken,
+			method:         http.MethodGet,
+			prepareRequest: prepareRequest(nil, cyLocation),
+			expectedStatus: http.StatusOK,
+			afterTest: func(t *testing.T, resp *http.Response) {
+				var page companiesResponse
+				if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+					t.Fatalf("could not decode response body: %+v", err)
+				}
+				if assert.Len(t, page.Companies, 2) {
+					assert.EqualValues(t, 12, page.Companies[0].ID)
+					assert.EqualValues(t, 13, page.Companies[1].ID)
+				}
+			},
+		},
+		{
+			name:           "fuzzy search",
+			path:           companiesURL + "?q=tesfour&match=fuzzy",
+			token:          viewerToken,
+			method:         http.MethodGet,
+			prepareRequest: prepareRequest(nil, cyLocation),
+			expectedStatus: http.StatusOK,
+			afterTest: func(t *testing.T, resp *http.Response) {
+				var page companiesResponse
+				if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+					t.Fatalf("could not decode response body: %+v", err)
+				}
+				if assert.NotEmpty(t, page.Companies) {
+					assert.EqualValues(t, 14, page.Companies[0].ID)
+				}
+			},
+		},
+		{
+			name:           "filter by creation time",
+			path:           companiesURL + "?created_after=2000-01-01T00:00:00Z&created_before=2000-01-02T00:00:00Z",
+			token:          viewerToken,
+			method:         http.MethodGet,
+			prepareRequest: prepareRequest(nil, cyLocation),
+			expectedStatus: http.StatusOK,
+			afterTest: func(t *testing.T, resp *http.Response) {
+				var page companiesResponse
+				if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+					t.Fatalf("could not decode response body: %+v", err)
+				}
+				assert.Empty(t, page.Companies)
+			},
+		},
+		{
+			name:           "filter changed since",
+			path:           companiesURL + "?updated_since=2000-01-01T00:00:00%2B02:00",
+			token:          viewerToken,
+			method:         http.MethodGet,
+			prepareRequest: prepareRequest(nil, cyLocation),
+			expectedStatus: http.StatusOK,
+			afterTest: func(t *testing.T, resp *http.Response) {
+				var page companiesResponse
+				if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+					t.Fatalf("could not decode response body: %+v", err)
+				}
+				assert.Len(t, page.Companies, 4)
+			},
+		},
+		{
+			name:           "fail: malformed time",
+			path:           companiesURL + "?created_after=yesterday",
+			token:          viewerToken,
+			method:         http.MethodGet,
+			prepareRequest: prepareRequest(nil, cyLocation),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "created_after: Invalid param\n",
+		},
+		{
+			name:           "fail: unknown match mode",
+			path:           companiesURL + "?q=test&match=regexp",
+			token:          viewerToken,
+			method:         http.MethodGet,
+			prepareRequest: prepareRequest(nil, cyLocation),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "match: Invalid param\n",
+		},
+		{
+			name:           "fail: sort by relevance without search",
+			path:           companiesURL + "?sort=-relevance",
+			token:          viewerToken,
+			method:         http.MethodGet,
+			prepareRequest: prepareRequest(nil, cyLocation),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "sort by relevance requires q: Invalid param\n",
+		},
+		{
+			name:           "fail: limit out of range",
+			path:           companiesURL + "?limit=0",
+			token:          viewerToken,
+			method:         http.MethodGet,
+			prepareRequest: prepareRequest(nil, cyLocation),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "limit: Invalid param\n",
+		},
+		{
+			name:           "fail: malformed cursor",
+			path:           companiesURL + "?cursor=bm90IGEgY3Vyc29y",
+			token:          viewerToken,
+			method:         http.MethodGet,
+			prepareRequest: prepareRequest(nil, cyLocation),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "cursor: Invalid param\n",
+		},
+		{
+			name: "metrics by route",
+			path: "/metrics",
+			afterTest: func(t *testing.T, resp *http.Response) {
+				body, err := ioutil.ReadAll(resp.Body)
+				assert.NoError(t, err)
+				assert.Contains(t, string(body), `companies_http_requests_total{method="GET",route="/api/v1/companies/{companyID}",status="200"}`)
+				assert.Contains(t, string(body), `companies_http_requests_total{method="GET",route="/api/v1/companies/",status="400"}`)
+				assert.Contains(t, string(body), `companies_db_query_duration_seconds_count{method="GetListByFilter",store="companies"}`)
+			},
+		},
+	}
+	checkTestCases(t, tt)
+}
+
+func TestGetCompaniesPagination(t *testing.T) {
+	//should be called in first test case
+	prepareDB := func(_ *testing.T, db *store) {
+		db.client.MustExec(`INSERT INTO ` + db.client.SchemaName + `.companies` +
+			`  ( id,        name,        code, country,        website,     phone) VALUES` +
+			`  ( 11,   'testOne',      '1111',    'cy',   'testone.cy',   '+001234')` +
+			`, ( 12,   'testTwo',      '2222',    'uk',   'testtwo.uk',   '+002345')` +
+			`, ( 13, 'testThree',      '3333',    'bg', 'testthree.bg',   '+003456')` +
+			`;`)
+	}
+
+	var nextCursor string
+	tt := []testCase{
+		{
+			name:           "first page",
+			path:           companiesURL + "?limit=2&sort=-name",
+			token:          viewerToken,
+			method:         http.MethodGet,
+			prepareDB:      prepareDB,
+			prepareRequest: prepareRequest(nil, cyLocation),
+			expectedStatus: http.StatusOK,
+			afterTest: func(t *testing.T, resp *http.Response) {
+				var page companiesResponse
+				if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+					t.Fatalf("could not decode response body: %+v", err)
+				}
+				if assert.Len(t, page.Companies, 2) {
+					assert.EqualValues(t, 12, page.Companies[0].ID)
+					assert.EqualValues(t, 13, page.Companies[1].ID)
+				}
+				assert.NotEmpty(t, page.NextCursor)
+				nextCursor = page.NextCursor
+			},
+		},
+		{
+			name:   "last page",
+			method: http.MethodGet,
+			prepareRequest: func(r *http.Request) {
+				q := r.URL.Query()
+				q.Set("cursor", nextCursor)
+				r.URL.RawQuery = q.Encode()
+			},
+			path:           companiesURL + "?limit=2&sort=-name",
+			token:          viewerToken,
+			expectedStatus: http.StatusOK,
+			afterTest: func(t *testing.T, resp *http.Response) {
+				var page companiesResponse
+				if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+					t.Fatalf("could not decode response body: %+v", err)
+				}
+				if assert.Len(t, page.Companies, 1) {
+					assert.EqualValues(t, 11, page.Companies[0].ID)
+				}
+				assert.Empty(t, page.NextCursor)
+			},
+		},
+		{
+			name:   "fail: cursor issued for another sort",
+			method: http.MethodGet,
+			prepareRequest: func(r *http.Request) {
+				q := r.URL.Query()
+				q.Set("cursor", nextCursor)
+				r.URL.RawQuery = q.Encode()
+			},
+			path:           companiesURL + "?limit=2&sort=name",
+			token:          viewerToken,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "cursor does not match sort: Invalid param\n",
+		},
 	}
 	checkTestCases(t, tt)
 }
@@ -243,9 +582,10 @@ func TestUpdateCompanies(t *testing.T) {
 	}
 	tt := []testCase{
 		{
-			name:           "success: no auth and location check required",
+			name:           "success: no location check required",
 			path:           companiesURL + "/11",
 			method:         http.MethodPut,
+			token:          userToken,
 			prepareDB:      prepareDB,
 			prepareRequest: prepareRequest(`{"name": "my company","code": "1235","country": "CY","website": "example.com","phone": "+79991123123"}`, ""),
 			expectedStatus: http.StatusNoContent,
@@ -255,13 +595,30 @@ func TestUpdateCompanies(t *testing.T) {
 				assert.NoError(t, err)
 				assert.NotNil(t, company)
 				assert.EqualValues(t, "1235", company.Code)
+				assert.EqualValues(t, "cy", company.Country, "country is stored lower case like created one")
 			},
 		},
+		{
+			name:           "fail: auth required",
+			path:           companiesURL + "/12",
+			method:         http.MethodPut,
+			prepareRequest: prepareRequest(`{"name": "my company","code": "1236","country": "CY","website": "example.com","phone": "+79991123123"}`, ""),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "fail: write permission required",
+			path:           companiesURL + "/12",
+			method:         http.MethodPatch,
+			token:          viewerToken,
+			prepareRequest: prepareRequest(`{"name": "Meta"}`, ""),
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   "permission denied\n",
+		},
 		{
 			name:           "fail: all fields required",
 			path:           companiesURL + "/12",
 			method:         http.MethodPut,
-			token:          testingToken,
+			token:          userToken,
 			prepareRequest: prepareRequest(`{"name": "my company", "website": "example.com","phone": "+79991123123"}`, cyLocation),
 			expectedStatus: http.StatusBadRequest,
 			expectedBody:   "code: Invalid param\n",
@@ -270,7 +627,7 @@ func TestUpdateCompanies(t *testing.T) {
 			name:           "success: partial update",
 			path:           companiesURL + "/13",
 			method:         http.MethodPatch,
-			token:          testingToken,
+			token:          userToken,
 			prepareRequest: prepareRequest(`{"name": "Meta","website": "google.com"}`, usLocation),
 			expectedStatus: http.StatusOK,
 			afterTest: func(t *testing.T, resp *http.Response) {
@@ -287,61 +644,910 @@ func TestUpdateCompanies(t *testing.T) {
 			name:           "fail: partial update company not found",
 			path:           companiesURL + "/99",
 			method:         http.MethodPatch,
-			token:          testingToken,
+			token:          userToken,
 			prepareRequest: prepareRequest(`{"name": "Meta","website": "google.com"}`, usLocation),
 			expectedStatus: http.StatusNotFound,
 			expectedBody:   "Company not found\n",
 		},
-	}
-	checkTestCases(t, tt)
-}
-
-func TestDeleteCompanies(t *testing.T) {
-	tt := []testCase{
 		{
-			name:           "fail: auth required",
-			path:           companiesURL + "/11",
-			method:         http.MethodDelete,
-			prepareRequest: prepareRequest(nil, cyLocation),
-			expectedStatus: http.StatusUnauthorized,
+			name:           "fail: patched code is taken",
+			path:           companiesURL + "/12",
+			method:         http.MethodPatch,
+			token:          userToken,
+			prepareRequest: prepareRequest(`{"code": "3333"}`, ""),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "Company with same code already exists\n",
 		},
 		{
-			name:           "fail: wrong location",
-			path:           companiesURL + "/11",
-			method:         http.MethodDelete,
-			token:          testingToken,
-			prepareRequest: prepareRequest(nil, usLocation),
-			expectedStatus: http.StatusForbidden,
-			expectedBody:   "your location is not allowed\n",
+			name:           "success: merge patch removes phone",
+			path:           companiesURL + "/12",
+			method:         http.MethodPatch,
+			token:          userToken,
+			prepareRequest: withContentType(model.MergePatchType, prepareRequest(`{"phone": null, "name": "Meta"}`, "")),
+			expectedStatus: http.StatusOK,
+			afterTest: func(t *testing.T, resp *http.Response) {
+				company := model.Company{}
+				if err := json.NewDecoder(resp.Body).Decode(&company); err != nil {
+					t.Fatalf("could not decode response body: %+v", err)
+				}
+				assert.EqualValues(t, "Meta", company.Name)
+				assert.Empty(t, company.Phone)
+			},
 		},
 		{
-			name:           "fail: company not found",
-			path:           companiesURL + "/9",
-			token:          testingToken,
-			method:         http.MethodDelete,
-			prepareRequest: prepareRequest(nil, cyLocation),
-			expectedStatus: http.StatusNotFound,
-			expectedBody:   "Company not found\n",
+			name:           "fail: merge patch removes required field",
+			path:           companiesURL + "/12",
+			method:         http.MethodPatch,
+			token:          userToken,
+			prepareRequest: withContentType(model.MergePatchType, prepareRequest(`{"code": null}`, "")),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "code: Invalid param\n",
 		},
 		{
-			name:   "success",
-			path:   companiesURL + "/11",
-			token:  testingToken,
-			method: http.MethodDelete,
-			prepareDB: func(_ *testing.T, db *store) {
-				db.client.MustExec(`INSERT INTO ` + db.client.SchemaName + `.companies` +
-					`  ( id,        name,        code, country,        website,     phone) VALUES` +
-					`  ( 11,   'testOne',      '1111',    'CY',   'testone.cy',   '+001234')` +
-					`;`)
-			},
-			prepareRequest: prepareRequest(nil, cyLocation),
-			expectedStatus: http.StatusNoContent,
-			checkDB: func(t *testing.T, stores *store) {
-				f := dataprovider.NewCompanyFilter().ByIDs(11)
-				company, err := stores.companyStorage.GetByFilter(context.Background(), f)
-				assert.NoError(t, err)
-				assert.Nil(t, company)
-			},
+			name:           "success: merge patch ignores unchanged read-only fields",
+			path:           companiesURL + "/12",
+			method:         http.MethodPatch,
+			token:          userToken,
+			prepareRequest: withContentType(model.MergePatchType, prepareRequest(`{"id": 12, "deleted_at": null, "name": "Meta"}`, "")),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "fail: merge patch changes read-only field",
+			path:           companiesURL + "/12",
+			method:         http.MethodPatch,
+			token:          userToken,
+			prepareRequest: withContentType(model.MergePatchType, prepareRequest(`{"id": 13}`, "")),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "id: Invalid param\n",
+		},
+		{
+			name:   "success: json patch",
+			path:   companiesURL + "/12",
+			method: http.MethodPatch,
+			token:  userToken,
+			prepareRequest: withContentType(model.JSONPatchType, prepareRequest(`[`+
+				`{"op": "test", "path": "/name", "value": "Meta"},`+
+				`{"op": "replace", "path": "/website", "value": "meta.com"},`+
+				`{"op": "add", "path": "/phone", "value": "+35799000000"}]`, "")),
+			expectedStatus: http.StatusOK,
+			afterTest: func(t *testing.T, resp *http.Response) {
+				company := model.Company{}
+				if err := json.NewDecoder(resp.Body).Decode(&company); err != nil {
+					t.Fatalf("could not decode response body: %+v", err)
+				}
+				assert.EqualValues(t, "meta.com", company.Website)
+				assert.EqualValues(t, "+35799000000", company.Phone)
+			},
+		},
+		{
+			name:           "fail: json patch test operation",
+			path:           companiesURL + "/12",
+			method:         http.MethodPatch,
+			token:          userToken,
+			prepareRequest: withContentType(model.JSONPatchType, prepareRequest(`[{"op": "test", "path": "/name", "value": "Google"}]`, "")),
+			expectedStatus: http.StatusConflict,
+			expectedBody:   "operation #0: /name: Patch test operation failed\n",
+		},
+		{
+			name:           "fail: json patch of read-only field",
+			path:           companiesURL + "/12",
+			method:         http.MethodPatch,
+			token:          userToken,
+			prepareRequest: withContentType(model.JSONPatchType, prepareRequest(`[{"op": "replace", "path": "/id", "value": 1}]`, "")),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "operation #0: id: Invalid param\n",
+		},
+		{
+			name:           "fail: unsupported patch format",
+			path:           companiesURL + "/12",
+			method:         http.MethodPatch,
+			token:          userToken,
+			prepareRequest: withContentType("text/plain", prepareRequest(`name=Meta`, "")),
+			expectedStatus: http.StatusUnsupportedMediaType,
+			expectedBody:   "text/plain: Unsupported content type\n",
+		},
+		{
+			name:           "get by id returns version",
+			path:           companiesURL + "/14",
+			token:          viewerToken,
+			expectedStatus: http.StatusOK,
+			afterTest: func(t *testing.T, resp *http.Response) {
+				assert.Equal(t, `"1"`, resp.Header.Get("ETag"))
+			},
+		},
+		{
+			name:           "fail: version mismatch",
+			path:           companiesURL + "/14",
+			method:         http.MethodPut,
+			token:          userToken,
+			prepareRequest: withIfMatch(`"2"`, prepareRequest(`{"name": "my company","code": "1237","country": "CY","website": "example.com","phone": "+79991123123"}`, "")),
+			expectedStatus: http.StatusPreconditionFailed,
+			expectedBody:   "Company was modified by another request\n",
+		},
+		{
+			name:           "success: version matches",
+			path:           companiesURL + "/14",
+			method:         http.MethodPatch,
+			token:          userToken,
+			prepareRequest: withIfMatch(`W/"1", "1"`, prepareRequest(`{"name": "Meta"}`, "")),
+			expectedStatus: http.StatusOK,
+			afterTest: func(t *testing.T, resp *http.Response) {
+				assert.Equal(t, `"2"`, resp.Header.Get("ETag"))
+			},
+		},
+		{
+			name:           "success: put returns new version",
+			path:           companiesURL + "/14",
+			method:         http.MethodPut,
+			token:          userToken,
+			prepareRequest: withIfMatch(`"2"`, prepareRequest(`{"name": "Meta","code": "4444","country": "CY","website": "meta.com"}`, "")),
+			expectedStatus: http.StatusNoContent,
+			afterTest: func(t *testing.T, resp *http.Response) {
+				assert.Equal(t, `"3"`, resp.Header.Get("ETag"))
+			},
+		},
+		{
+			name:           "success: put with version of previous put",
+			path:           companiesURL + "/14",
+			method:         http.MethodPut,
+			token:          userToken,
+			prepareRequest: withIfMatch(`"3"`, prepareRequest(`{"name": "Meta","code": "4444","country": "CY","website": "meta.org"}`, "")),
+			expectedStatus: http.StatusNoContent,
+			afterTest: func(t *testing.T, resp *http.Response) {
+				assert.Equal(t, `"4"`, resp.Header.Get("ETag"))
+			},
+		},
+		{
+			name:           "fail: stale version",
+			path:           companiesURL + "/14",
+			method:         http.MethodDelete,
+			token:          adminToken,
+			prepareRequest: withIfMatch(`"1"`, prepareRequest(nil, cyLocation)),
+			expectedStatus: http.StatusPreconditionFailed,
+			expectedBody:   "Company was modified by another request\n",
+		},
+	}
+	checkTestCases(t, tt)
+}
+
+func TestDeleteCompanies(t *testing.T) {
+	tt := []testCase{
+		{
+			name:           "fail: auth required",
+			path:           companiesURL + "/11",
+			method:         http.MethodDelete,
+			prepareRequest: prepareRequest(nil, cyLocation),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "fail: wrong location",
+			path:           companiesURL + "/11",
+			method:         http.MethodDelete,
+			token:          adminToken,
+			prepareRequest: prepareRequest(nil, usLocation),
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   "your location is not allowed\n",
+		},
+		{
+			name:           "fail: delete permission required",
+			path:           companiesURL + "/11",
+			method:         http.MethodDelete,
+			token:          userToken,
+			prepareRequest: prepareRequest(nil, cyLocation),
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   "permission denied\n",
+		},
+		{
+			name:           "fail: company not found",
+			path:           companiesURL + "/9",
+			token:          adminToken,
+			method:         http.MethodDelete,
+			prepareRequest: prepareRequest(nil, cyLocation),
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "Company not found\n",
+		},
+		{
+			name:   "success",
+			path:   companiesURL + "/11",
+			token:  adminToken,
+			method: http.MethodDelete,
+			prepareDB: func(_ *testing.T, db *store) {
+				db.client.MustExec(`INSERT INTO ` + db.client.SchemaName + `.companies` +
+					`  ( id,        name,        code, country,        website,     phone) VALUES` +
+					`  ( 11,   'testOne',      '1111',    'CY',   'testone.cy',   '+001234')` +
+					`;`)
+			},
+			prepareRequest: prepareRequest(nil, cyLocation),
+			expectedStatus: http.StatusNoContent,
+			checkDB: func(t *testing.T, stores *store) {
+				f := dataprovider.NewCompanyFilter().ByIDs(11)
+				company, err := stores.companyStorage.GetByFilter(context.Background(), f)
+				assert.NoError(t, err)
+				assert.Nil(t, company)
+
+				company, err = stores.companyStorage.GetByFilter(context.Background(), f.WithDeleted(true))
+				assert.NoError(t, err)
+				if assert.NotNil(t, company) {
+					assert.NotNil(t, company.DeletedAt)
+				}
+
+				var events int
+				err = stores.client.Get(&events, `SELECT count(*) FROM `+stores.client.SchemaName+`.outbox WHERE event_type = $1`,
+					model.EventCompanyDeleted)
+				assert.NoError(t, err)
+				assert.Equal(t, 1, events)
+			},
+		},
+		{
+			name:           "list deleted companies",
+			path:           companiesURL + "?only_deleted=true",
+			token:          viewerToken,
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			afterTest: func(t *testing.T, resp *http.Response) {
+				var page companiesResponse
+				if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+					t.Fatalf("could not decode response body: %+v", err)
+				}
+				if assert.Len(t, page.Companies, 1) {
+					assert.EqualValues(t, 11, page.Companies[0].ID)
+				}
+			},
+		},
+		{
+			name:           "fail: restore permission required",
+			path:           companiesURL + "/11/restore",
+			method:         http.MethodPost,
+			token:          userToken,
+			prepareRequest: prepareRequest(nil, cyLocation),
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   "permission denied\n",
+		},
+		{
+			name:           "fail: restore company not found",
+			path:           companiesURL + "/9/restore",
+			method:         http.MethodPost,
+			token:          adminToken,
+			prepareRequest: prepareRequest(nil, cyLocation),
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "Company not found\n",
+		},
+		{
+			name:           "restore",
+			path:           companiesURL + "/11/restore",
+			method:         http.MethodPost,
+			token:          adminToken,
+			prepareRequest: prepareRequest(nil, cyLocation),
+			expectedStatus: http.StatusNoContent,
+			checkDB: func(t *testing.T, stores *store) {
+				f := dataprovider.NewCompanyFilter().ByIDs(11)
+				company, err := stores.companyStorage.GetByFilter(context.Background(), f)
+				assert.NoError(t, err)
+				if assert.NotNil(t, company) {
+					assert.Nil(t, company.DeletedAt)
+				}
+
+				var events int
+				err = stores.client.Get(&events, `SELECT count(*) FROM `+stores.client.SchemaName+`.outbox WHERE event_type = $1`,
+					model.EventCompanyRestored)
+				assert.NoError(t, err)
+				assert.Equal(t, 1, events)
+			},
+		},
+	}
+	checkTestCases(t, tt)
+}
+
+func TestBatchCompanies(t *testing.T) {
+	expectStatuses := func(statuses ...int) func(t *testing.T, resp *http.Response) {
+		return func(t *testing.T, resp *http.Response) {
+			var batch batchResponse
+			if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+				t.Fatalf("could not decode response body: %+v", err)
+			}
+			if assert.Len(t, batch.Results, len(statuses)) {
+				for n, status := range statuses {
+					assert.Equal(t, n, batch.Results[n].Index)
+					assert.Equalf(t, status, batch.Results[n].Status, "status of company #%d", n)
+				}
+			}
+		}
+	}
+	countCompanies := func(t *testing.T, stores *store, codes ...string) int {
+		f := dataprovider.NewCompanyFilter().ByCodes(codes...)
+		companies, err := stores.companyStorage.GetListByFilter(context.Background(), f)
+		assert.NoError(t, err)
+		return len(companies)
+	}
+
+	tt := []testCase{
+		{
+			name:           "fail: write permission required",
+			path:           batchCreateURL,
+			method:         http.MethodPost,
+			token:          viewerToken,
+			prepareRequest: prepareRequest(`{"companies": []}`, cyLocation),
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   "permission denied\n",
+		},
+		{
+			name:           "fail: wrong location",
+			path:           batchCreateURL,
+			method:         http.MethodPost,
+			token:          userToken,
+			prepareRequest: prepareRequest(`{"companies": []}`, usLocation),
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   "your location is not allowed\n",
+		},
+		{
+			name:           "fail: empty batch",
+			path:           batchCreateURL,
+			method:         http.MethodPost,
+			token:          userToken,
+			prepareRequest: prepareRequest(`{"companies": []}`, cyLocation),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "companies: Invalid param\n",
+		},
+		{
+			name:   "fail: atomic batch aborted",
+			path:   batchCreateURL,
+			method: http.MethodPost,
+			token:  userToken,
+			prepareRequest: prepareRequest(`{"companies": [`+
+				`{"name": "batch one", "code": "b1", "country": "CY", "website": "one.cy", "phone": "+3571"},`+
+				`{"name": "batch two", "code": "b2", "country": "CY", "website": "two.cy"}]}`, cyLocation),
+			expectedStatus: http.StatusUnprocessableEntity,
+			afterTest:      expectStatuses(http.StatusFailedDependency, http.StatusBadRequest),
+			checkDB: func(t *testing.T, stores *store) {
+				assert.Equal(t, 0, countCompanies(t, stores, "b1", "b2"))
+			},
+		},
+		{
+			name:   "create partially",
+			path:   batchCreateURL,
+			method: http.MethodPost,
+			token:  userToken,
+			prepareRequest: prepareRequest(`{"atomic": false, "companies": [`+
+				`{"name": "batch one", "code": "b1", "country": "CY", "website": "one.cy", "phone": "+3571"},`+
+				`{"name": "batch two", "code": "b2", "country": "CY", "website": "two.cy", "phone": "+3572"},`+
+				`{"name": "batch copy", "code": "b1", "country": "CY", "website": "copy.cy", "phone": "+3573"}]}`, cyLocation),
+			expectedStatus: http.StatusMultiStatus,
+			afterTest:      expectStatuses(http.StatusCreated, http.StatusCreated, http.StatusBadRequest),
+			checkDB: func(t *testing.T, stores *store) {
+				assert.Equal(t, 2, countCompanies(t, stores, "b1", "b2"))
+
+				var events int
+				err := stores.client.Get(&events, `SELECT count(*) FROM `+stores.client.SchemaName+`.outbox WHERE event_type = $1`,
+					model.EventCompanyBatch)
+				assert.NoError(t, err)
+				assert.Equal(t, 1, events)
+			},
+		},
+		{
+			name:   "update partially",
+			path:   batchUpdateURL,
+			method: http.MethodPost,
+			token:  userToken,
+			prepareDB: func(_ *testing.T, db *store) {
+				db.client.MustExec(`INSERT INTO ` + db.client.SchemaName + `.companies` +
+					`  ( id,        name,        code, country,        website,     phone) VALUES` +
+					`  ( 21,   'testOne',      '2111',    'CY',   'testone.cy',   '+001234')` +
+					`, ( 22,   'testTwo',      '2222',    'CY',   'testtwo.cy',   '+001235')` +
+					`;`)
+			},
+			prepareRequest: prepareRequest(`{"atomic": false, "companies": [`+
+				`{"id": 21, "name": "renamed", "code": "2111", "country": "CY", "website": "testone.cy", "phone": "+001234"},`+
+				`{"id": 22, "name": "testTwo", "code": "2111", "country": "CY", "website": "testtwo.cy", "phone": "+001235"},`+
+				`{"id": 29, "name": "unknown", "code": "2999", "country": "CY", "website": "unknown.cy", "phone": "+001239"}]}`, cyLocation),
+			expectedStatus: http.StatusMultiStatus,
+			afterTest:      expectStatuses(http.StatusNoContent, http.StatusBadRequest, http.StatusNotFound),
+			checkDB: func(t *testing.T, stores *store) {
+				company, err := stores.companyStorage.GetByFilter(context.Background(), dataprovider.NewCompanyFilter().ByIDs(21))
+				assert.NoError(t, err)
+				if assert.NotNil(t, company) {
+					assert.Equal(t, "renamed", company.Name)
+				}
+			},
+		},
+		{
+			name:           "fail: delete permission required",
+			path:           batchDeleteURL,
+			method:         http.MethodPost,
+			token:          userToken,
+			prepareRequest: prepareRequest(`{"companies": [{"id": 21}, {"id": 22}]}`, cyLocation),
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   "permission denied\n",
+		},
+		{
+			name:           "fail: delete stale version",
+			path:           batchDeleteURL,
+			method:         http.MethodPost,
+			token:          adminToken,
+			prepareRequest: prepareRequest(`{"companies": [{"id": 21, "version": 1}, {"id": 22}]}`, cyLocation),
+			expectedStatus: http.StatusUnprocessableEntity,
+			afterTest:      expectStatuses(http.StatusPreconditionFailed, http.StatusFailedDependency),
+		},
+		{
+			name:           "delete",
+			path:           batchDeleteURL,
+			method:         http.MethodPost,
+			token:          adminToken,
+			prepareRequest: prepareRequest(`{"companies": [{"id": 21, "version": 2}, {"id": 22}]}`, cyLocation),
+			expectedStatus: http.StatusOK,
+			afterTest:      expectStatuses(http.StatusNoContent, http.StatusNoContent),
+			checkDB: func(t *testing.T, stores *store) {
+				assert.Equal(t, 0, countCompanies(t, stores, "2111", "2222"))
+
+				var revisions int
+				err := stores.client.Get(&revisions, `SELECT count(*) FROM `+stores.client.SchemaName+`.company_revisions WHERE action = $1`,
+					model.EventCompanyDeleted)
+				assert.NoError(t, err)
+				assert.Equal(t, 2, revisions)
+			},
+		},
+	}
+	checkTestCases(t, tt)
+}
+
+func TestImportExportCompanies(t *testing.T) {
+	const importCSV = "name,code,country,website,phone\n" +
+		"import one,i1,CY,one.cy,+3571\n" +
+		"import two,i2,CY,two.cy\n" +
+		"import three,i3,CY,three.cy,+3573\n"
+
+	expectImport := func(accepted, failed int) func(t *testing.T, resp *http.Response) {
+		return func(t *testing.T, resp *http.Response) {
+			var result importResponse
+			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+				t.Fatalf("could not decode response body: %+v", err)
+			}
+			assert.Equal(t, accepted, result.Accepted)
+			assert.Equal(t, failed, result.Failed)
+			if assert.Len(t, result.Errors, failed) && failed > 0 {
+				assert.Equal(t, 3, result.Errors[0].Row)
+			}
+		}
+	}
+	countCompanies := func(t *testing.T, stores *store, expected int) {
+		f := dataprovider.NewCompanyFilter().ByCodes("i1", "i2", "i3", "i4")
+		companies, err := stores.companyStorage.GetListByFilter(context.Background(), f)
+		assert.NoError(t, err)
+		assert.Len(t, companies, expected)
+	}
+
+	tt := []testCase{
+		{
+			name:           "fail: import auth required",
+			path:           companiesURL + "/import",
+			method:         http.MethodPost,
+			prepareRequest: prepareRequest(importCSV, cyLocation),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "fail: unknown format",
+			path:           companiesURL + "/import?format=xml",
+			method:         http.MethodPost,
+			token:          userToken,
+			prepareRequest: prepareRequest(importCSV, cyLocation),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "format: Invalid param\n",
+		},
+		{
+			name:           "dry run",
+			path:           companiesURL + "/import?format=csv&dry_run=true",
+			method:         http.MethodPost,
+			token:          userToken,
+			prepareRequest: prepareRequest(importCSV, cyLocation),
+			afterTest:      expectImport(2, 1),
+			checkDB: func(t *testing.T, stores *store) {
+				countCompanies(t, stores, 0)
+			},
+		},
+		{
+			name:           "import csv",
+			path:           companiesURL + "/import?format=csv",
+			method:         http.MethodPost,
+			token:          userToken,
+			prepareRequest: prepareRequest(importCSV, cyLocation),
+			afterTest:      expectImport(2, 1),
+			checkDB: func(t *testing.T, stores *store) {
+				countCompanies(t, stores, 2)
+			},
+		},
+		{
+			name:   "import ndjson with error report",
+			path:   companiesURL + "/import?format=ndjson&report=csv",
+			method: http.MethodPost,
+			token:  userToken,
+			prepareRequest: prepareRequest(
+				`{"name": "import one", "code": "i1", "country": "CY", "website": "one.cy", "phone": "+3571"}`+"\n"+
+					`{"name": "import four", "code": "i4", "country": "CY", "website": "four.cy", "phone": "+3574"}`+"\n",
+				cyLocation),
+			expectedBody: "row,code,error\n1,i1,Company with same code already exists\n",
+			checkDB: func(t *testing.T, stores *store) {
+				countCompanies(t, stores, 3)
+			},
+		},
+		{
+			name:  "export csv",
+			path:  companiesURL + "/export?format=csv&codes=i1,i3,i4&sort=code",
+			token: viewerToken,
+			afterTest: func(t *testing.T, resp *http.Response) {
+				assert.Equal(t, "text/csv", resp.Header.Get("Content-Type"))
+				records, err := csv.NewReader(resp.Body).ReadAll()
+				assert.NoError(t, err)
+				if assert.Len(t, records, 4) {
+					assert.Equal(t, "id", records[0][0])
+					assert.Equal(t, []string{"i1", "i3", "i4"}, []string{records[1][2], records[2][2], records[3][2]})
+				}
+			},
+		},
+		{
+			name:  "export ndjson",
+			path:  companiesURL + "/export?format=ndjson&codes=i1,i3&sort=-code",
+			token: viewerToken,
+			afterTest: func(t *testing.T, resp *http.Response) {
+				assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+				dec := json.NewDecoder(resp.Body)
+				var codes []string
+				for dec.More() {
+					var company model.Company
+					if err := dec.Decode(&company); err != nil {
+						t.Fatalf("could not decode response body: %+v", err)
+					}
+					codes = append(codes, company.Code)
+				}
+				assert.Equal(t, []string{"i3", "i1"}, codes)
+			},
+		},
+	}
+	checkTestCases(t, tt)
+}
+
+func TestUsers(t *testing.T) {
+	//should be called in first test case
+	prepareDB := func(t *testing.T, db *store) {
+		hash, err := bcrypt.GenerateFromPassword([]byte("password1"), bcrypt.MinCost)
+		assert.NoError(t, err)
+		db.client.MustExec(`INSERT INTO `+db.client.SchemaName+`.users`+
+			`  ( id,   username,  password_hash,   role, disabled) VALUES`+
+			`  ( 11,    'user1',             $1, 'user',    false)`+
+			`, ( 12,    'user2',             $1, 'user',     true)`+
+			`;`, string(hash))
+	}
+
+	tt := []testCase{
+		{
+			name:           "sign in",
+			path:           signInURL,
+			method:         http.MethodPost,
+			prepareDB:      prepareDB,
+			prepareRequest: prepareRequest(`{"login": "user1","password": "password1"}`, ""),
+			expectedStatus: http.StatusOK,
+			afterTest: func(t *testing.T, resp *http.Response) {
+				body, err := ioutil.ReadAll(resp.Body)
+				assert.NoError(t, err)
+				assert.NotEmpty(t, body)
+			},
+		},
+		{
+			name:           "fail: sign in with wrong password",
+			path:           signInURL,
+			method:         http.MethodPost,
+			prepareRequest: prepareRequest(`{"login": "user1","password": "password2"}`, ""),
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   "Invalid username or password\n",
+		},
+		{
+			name:           "fail: sign in disabled user",
+			path:           signInURL,
+			method:         http.MethodPost,
+			prepareRequest: prepareRequest(`{"login": "user2","password": "password1"}`, ""),
+			expectedStatus: http.StatusUnauthorized,
+			expectedBody:   "Invalid username or password\n",
+		},
+		{
+			name:           "fail: create user requires admin",
+			path:           usersURL,
+			method:         http.MethodPost,
+			token:          userToken,
+			prepareRequest: prepareRequest(`{"username": "user3","password": "password3"}`, ""),
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "fail: create user with short password",
+			path:           usersURL,
+			method:         http.MethodPost,
+			token:          adminToken,
+			prepareRequest: prepareRequest(`{"username": "user3","password": "short"}`, ""),
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "password: Invalid param\n",
+		},
+		{
+			name:           "fail: create existing user",
+			path:           usersURL,
+			method:         http.MethodPost,
+			token:          adminToken,
+			prepareRequest: prepareRequest(`{"username": "user1","password": "password3"}`, ""),
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "create user",
+			path:           usersURL,
+			method:         http.MethodPost,
+			token:          adminToken,
+			prepareRequest: prepareRequest(`{"username": "user3","password": "password3"}`, ""),
+			expectedStatus: http.StatusCreated,
+			checkDB: func(t *testing.T, stores *store) {
+				user, err := stores.userStorage.GetByFilter(context.Background(), dataprovider.NewUserFilter().ByUsernames("user3"))
+				assert.NoError(t, err)
+				if assert.NotNil(t, user) {
+					assert.Equal(t, model.RoleUser, user.Role)
+					assert.NotEqual(t, "password3", user.PasswordHash)
+				}
+			},
+		},
+		{
+			name:           "disable user",
+			path:           usersURL + "/11/disable",
+			method:         http.MethodPost,
+			token:          adminToken,
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "fail: sign in after disabling",
+			path:           signInURL,
+			method:         http.MethodPost,
+			prepareRequest: prepareRequest(`{"login": "user1","password": "password1"}`, ""),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "reset password",
+			path:           usersURL + "/12/password",
+			method:         http.MethodPost,
+			token:          adminToken,
+			prepareRequest: prepareRequest(`{"password": "new password"}`, ""),
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "fail: reset password of unknown user",
+			path:           usersURL + "/99/password",
+			method:         http.MethodPost,
+			token:          adminToken,
+			prepareRequest: prepareRequest(`{"password": "new password"}`, ""),
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "User not found\n",
+		},
+	}
+	checkTestCases(t, tt)
+}
+
+func TestCompanyHistory(t *testing.T) {
+	var cursor string
+	decodeHistory := func(t *testing.T, resp *http.Response) historyResponse {
+		var page historyResponse
+		if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+			t.Fatalf("could not decode response body: %+v", err)
+		}
+		return page
+	}
+
+	tt := []testCase{
+		{
+			name:   "update",
+			path:   companiesURL + "/11",
+			method: http.MethodPut,
+			token:  userToken,
+			prepareDB: func(_ *testing.T, db *store) {
+				db.client.MustExec(`INSERT INTO ` + db.client.SchemaName + `.companies` +
+					`  ( id,        name,        code, country,        website,     phone) VALUES` +
+					`  ( 11,   'testOne',      '1111',    'cy',   'testone.cy',   '+001234')` +
+					`;`)
+			},
+			prepareRequest: prepareRequest(`{"name": "my company","code": "1235","country": "CY","website": "example.com","phone": "+79991123123"}`, cyLocation),
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "patch",
+			path:           companiesURL + "/11",
+			method:         http.MethodPatch,
+			token:          userToken,
+			prepareRequest: prepareRequest(`{"name": "Meta"}`, cyLocation),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "fail: admin permission required",
+			path:           companiesURL + "/11/history",
+			token:          userToken,
+			expectedStatus: http.StatusForbidden,
+			expectedBody:   "permission denied\n",
+		},
+		{
+			name:           "fail: company not found",
+			path:           companiesURL + "/9/history",
+			token:          adminToken,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "Company not found\n",
+		},
+		{
+			name:           "latest revision",
+			path:           companiesURL + "/11/history?limit=1",
+			token:          adminToken,
+			expectedStatus: http.StatusOK,
+			afterTest: func(t *testing.T, resp *http.Response) {
+				page := decodeHistory(t, resp)
+				if assert.Len(t, page.Revisions, 1) {
+					revision := page.Revisions[0]
+					assert.Equal(t, model.EventCompanyUpdated, revision.Action)
+					assert.Equal(t, "static-"+model.RoleUser, revision.Actor)
+					assert.Equal(t, cyLocation, revision.ClientIP)
+					assert.Contains(t, string(revision.Before), `"name":"my company"`)
+					assert.Contains(t, string(revision.After), `"name":"Meta"`)
+				}
+				assert.NotEmpty(t, page.NextCursor)
+				cursor = page.NextCursor
+			},
+		},
+		{
+			name:  "previous revision",
+			path:  companiesURL + "/11/history",
+			token: adminToken,
+			prepareRequest: func(r *http.Request) {
+				r.URL.RawQuery = url.Values{"limit": {"1"}, "cursor": {cursor}}.Encode()
+			},
+			expectedStatus: http.StatusOK,
+			afterTest: func(t *testing.T, resp *http.Response) {
+				page := decodeHistory(t, resp)
+				if assert.Len(t, page.Revisions, 1) {
+					assert.Contains(t, string(page.Revisions[0].Before), `"name":"testOne"`)
+				}
+				assert.Empty(t, page.NextCursor)
+			},
+		},
+	}
+	checkTestCases(t, tt)
+}
+
+func TestSessions(t *testing.T) {
+	//should be called in first test case
+	prepareDB := func(t *testing.T, db *store) {
+		hash, err := bcrypt.GenerateFromPassword([]byte("password1"), bcrypt.MinCost)
+		assert.NoError(t, err)
+		db.client.MustExec(`INSERT INTO `+db.client.SchemaName+`.users`+
+			`  ( id,   username,  password_hash,   role) VALUES`+
+			`  ( 11,    'user1',             $1, 'user')`+
+			`;`, string(hash))
+	}
+
+	var first, second, third tokensResponse
+	decodeTokens := func(dst *tokensResponse) func(t *testing.T, resp *http.Response) {
+		return func(t *testing.T, resp *http.Response) {
+			if err := json.NewDecoder(resp.Body).Decode(dst); err != nil {
+				t.Fatalf("could not decode response body: %+v", err)
+			}
+			assert.NotEmpty(t, dst.AccessToken)
+			assert.NotEmpty(t, dst.RefreshToken)
+		}
+	}
+	withRefreshToken := func(token *tokensResponse) func(r *http.Request) {
+		return func(r *http.Request) {
+			prepareRequest(map[string]string{"refresh_token": token.RefreshToken}, "")(r)
+		}
+	}
+	withAccessToken := func(token *tokensResponse) func(r *http.Request) {
+		return func(r *http.Request) {
+			prepareRequest(map[string]string{"refresh_token": token.RefreshToken}, "")(r)
+			r.Header.Set("Authorization", "Bearer "+token.AccessToken)
+		}
+	}
+
+	tt := []testCase{
+		{
+			name:           "sign in",
+			path:           signInURL,
+			method:         http.MethodPost,
+			prepareDB:      prepareDB,
+			prepareRequest: prepareRequest(`{"login": "user1","password": "password1"}`, ""),
+			expectedStatus: http.StatusOK,
+			afterTest:      decodeTokens(&first),
+		},
+		{
+			name:           "refresh",
+			path:           refreshURL,
+			method:         http.MethodPost,
+			prepareRequest: withRefreshToken(&first),
+			expectedStatus: http.StatusOK,
+			afterTest:      decodeTokens(&second),
+		},
+		{
+			name:           "fail: refresh token reused",
+			path:           refreshURL,
+			method:         http.MethodPost,
+			prepareRequest: withRefreshToken(&first),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "fail: refresh after reuse revoked all sessions",
+			path:           refreshURL,
+			method:         http.MethodPost,
+			prepareRequest: withRefreshToken(&second),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "sign out",
+			path:           signOutURL,
+			method:         http.MethodPost,
+			prepareRequest: withAccessToken(&second),
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "fail: revoked access token",
+			path:           signOutURL,
+			method:         http.MethodPost,
+			prepareRequest: withAccessToken(&second),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "sign in again",
+			path:           signInURL,
+			method:         http.MethodPost,
+			prepareRequest: prepareRequest(`{"login": "user1","password": "password1"}`, ""),
+			expectedStatus: http.StatusOK,
+			afterTest:      decodeTokens(&third),
+		},
+		{
+			name:           "reset password",
+			path:           usersURL + "/11/password",
+			method:         http.MethodPost,
+			token:          adminToken,
+			prepareRequest: prepareRequest(`{"password": "new password"}`, ""),
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "fail: refresh after password reset",
+			path:           refreshURL,
+			method:         http.MethodPost,
+			prepareRequest: withRefreshToken(&third),
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "symmetric key is not published",
+			path:           "/.well-known/jwks.json",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"keys":[]}` + "\n",
+		},
+	}
+	checkTestCases(t, tt)
+}
+
+func TestHealth(t *testing.T) {
+	tt := []testCase{
+		{
+			name:           "live",
+			path:           "/internal/live",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"status":"up"}` + "\n",
+		},
+		{
+			name:           "ready",
+			path:           "/internal/ready",
+			expectedStatus: http.StatusOK,
+			afterTest: func(t *testing.T, resp *http.Response) {
+				report := healthReport{}
+				assert.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+				assert.Equal(t, statusUp, report.Status)
+				if assert.Len(t, report.Checks, 1) {
+					assert.Equal(t, "postgres", report.Checks[0].Name)
+					assert.Equal(t, statusUp, report.Checks[0].Status)
+					assert.True(t, report.Checks[0].Critical)
+				}
+			},
+		},
+		{
+			name:           "legacy health is liveness",
+			path:           "/internal/health",
+			expectedStatus: http.StatusOK,
 		},
 	}
 	checkTestCases(t, tt)
@@ -351,6 +1557,11 @@ func checkTestCases(t *testing.T, tt []testCase) {
 	logger, _ := zap.NewDevelopment()
 	defaultConf, _ := config.New("", logger)
 	defaultConf.DB.SchemaName = "xm_test"
+	defaultConf.Geofence.Default.AllowedCIDRs = []string{officeNetwork}
+	defaultConf.Geofence.Default.DeniedCIDRs = []string{guestNetwork}
+	defaultConf.API.TrustedProxies = []string{"127.0.0.0/8", "::1/128"}
+	defaultConf.API.JWTAlgorithm = "HS256"
+	defaultConf.API.JWTKey = "secret of integration tests, 32+ bytes long"
 
 	dbClient, err := database.NewClient(defaultConf)
 	if err != nil {
@@ -360,11 +1571,27 @@ func checkTestCases(t *testing.T, tt []testCase) {
 		panic(err)
 	}
 	storage := pg.NewCompanyStorage(dbClient, logger)
+	outboxStorage := pg.NewOutboxStorage(dbClient, logger)
+	revisionStorage := pg.NewRevisionStorage(dbClient, logger)
+	companiesService := controller.NewCompaniesService(defaultConf, storage, outboxStorage, revisionStorage, dbClient)
+	userStorage := pg.NewUserStorage(dbClient, logger)
+	tokenStorage := pg.NewTokenStorage(dbClient, logger)
+	usersService := controller.NewUsersService(defaultConf, userStorage, tokenStorage, dbClient)
+	sessionsService := controller.NewSessionsService(defaultConf, userStorage, tokenStorage, dbClient)
 
-	mqMock := configureMqMock(service.NewMessageQueueMock(t))
-	companiesService := controller.NewCompaniesService(defaultConf, storage, mqMock)
+	keys, err := auth.NewKeySet(defaultConf, logger)
+	if err != nil {
+		panic(err)
+	}
+	authenticator := auth.NewStaticAuthenticator(map[string]string{
+		viewerToken: model.RoleViewer,
+		userToken:   model.RoleUser,
+		adminToken:  model.RoleAdmin,
+	}, auth.NewJWTAuthenticator(keys, sessionsService))
 
-	srv, err := NewServer(defaultConf, companiesService, configureIpCheckerMock(service.NewIpCheckerMock(t)))
+	srv, err := NewServer(defaultConf, companiesService, usersService, sessionsService, keys, authenticator,
+		configureIpCheckerMock(service.NewIpCheckerMock(t)),
+		HealthCheck{Name: "postgres", Critical: true, Check: dbClient.StatusCheck})
 	if err != nil {
 		panic(err)
 	}
@@ -372,6 +1599,7 @@ func checkTestCases(t *testing.T, tt []testCase) {
 	store := &store{
 		client:         dbClient,
 		companyStorage: storage,
+		userStorage:    userStorage,
 	}
 
 	for _, tc := range tt {
@@ -387,9 +1615,7 @@ func checkTestCases(t *testing.T, tt []testCase) {
 			}
 
 			// prepare request
-			baseURL := "/api/v1"
-
-			URL := h.URL + baseURL + tc.path
+			URL := h.URL + tc.path
 
 			method := http.MethodGet
 			if tc.method != "" {
@@ -487,6 +1713,7 @@ type testCase struct {
 type store struct {
 	client         *database.Client
 	companyStorage dataprovider.CompaniesStorage
+	userStorage    dataprovider.UsersStorage
 }
 
 func (s *store) dropSchema(t *testing.T) {
@@ -512,9 +1739,18 @@ func configureIpCheckerMock(mock *service.IpCheckerMock) *service.IpCheckerMock
 	return mock
 }
 
-func configureMqMock(mock *service.MessageQueueMock) *service.MessageQueueMock {
-	mock.NotifyCompanyUpdatedMock.Return(nil)
-	return mock
+func withContentType(contentType string, prepare func(*http.Request)) func(*http.Request) {
+	return func(r *http.Request) {
+		prepare(r)
+		r.Header.Set("Content-type", contentType)
+	}
+}
+
+func withIfMatch(tag string, prepare func(*http.Request)) func(*http.Request) {
+	return func(r *http.Request) {
+		prepare(r)
+		r.Header.Set("If-Match", tag)
+	}
 }
 
 func prepareRequest(body interface{}, location string) func(*http.Request) {
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Statuses of readiness report and its checks.
+const (
+	statusUp       = "up"
+	statusDown     = "down"
+	statusDegraded = "degraded"
+)
+
+// HealthCheck is a dependency checked by readiness probe, the service isn't ready while
+// any critical dependency is down.
+type HealthCheck struct {
+	Name     string
+	Critical bool
+	Check    func(ctx context.Context) error
+}
+
+type healthReport struct {
+	Status string        `json:"status"`
+	Checks []checkResult `json:"checks,omitempty"`
+}
+
+type checkResult struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	Critical  bool    `json:"critical"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// CachedCheck runs check at most once per interval, its last result is returned meanwhile.
+// Concurrent probes wait for the running check instead of starting their own. Check cut off by
+// the probe's context isn't cached, so that the next probe runs it again.
+func CachedCheck(interval time.Duration, check func(ctx context.Context) error) func(ctx context.Context) error {
+	var (
+		mu        sync.Mutex
+		err       error
+		checkedAt time.Time
+	)
+	return func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !checkedAt.IsZero() && time.Since(checkedAt) < interval {
+			return err
+		}
+
+		result := check(ctx)
+		if ctx.Err() != nil || errors.Is(result, context.DeadlineExceeded) || errors.Is(result, context.Canceled) {
+			return result
+		}
+		err, checkedAt = result, time.Now()
+		return err
+	}
+}
+
+// live reports the process is able to serve requests, dependencies aren't checked.
+func (srv *Server) live(w http.ResponseWriter, _ *http.Request) {
+	respondHealth(w, healthReport{Status: statusUp}, http.StatusOK)
+}
+
+// ready checks all dependencies concurrently, status is 503 if a critical one is down.
+// Failure of non-critical one is reported as degraded with 200.
+func (srv *Server) ready(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), srv.cfg.Health.Timeout)
+	defer cancel()
+
+	report := healthReport{
+		Status: statusUp,
+		Checks: make([]checkResult, len(srv.checks)),
+	}
+
+	var wg sync.WaitGroup
+	for n, check := range srv.checks {
+		wg.Add(1)
+		go func(n int, check HealthCheck) {
+			defer wg.Done()
+			report.Checks[n] = runCheck(ctx, check)
+		}(n, check)
+	}
+	wg.Wait()
+
+	code := http.StatusOK
+	for _, result := range report.Checks {
+		switch {
+		case result.Status == statusUp:
+		case result.Critical:
+			report.Status = statusDown
+			code = http.StatusServiceUnavailable
+		case report.Status == statusUp:
+			report.Status = statusDegraded
+		}
+	}
+
+	respondHealth(w, report, code)
+}
+
+func runCheck(ctx context.Context, check HealthCheck) checkResult {
+	start := time.Now()
+	err := check.Check(ctx)
+
+	result := checkResult{
+		Name:      check.Name,
+		Status:    statusUp,
+		Critical:  check.Critical,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = statusDown
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+func respondHealth(w http.ResponseWriter, report healthReport, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		respondError(w, err)
+	}
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCachedCheck(t *testing.T) {
+	failure := errors.New("provider is down")
+	calls := 0
+	result := failure
+	check := CachedCheck(time.Millisecond*50, func(context.Context) error {
+		calls++
+		return result
+	})
+
+	assert.ErrorIs(t, check(context.Background()), failure)
+	result = nil
+	assert.ErrorIs(t, check(context.Background()), failure, "the last result is reported within interval")
+	assert.Equal(t, 1, calls)
+
+	time.Sleep(time.Millisecond * 60)
+	assert.NoError(t, check(context.Background()))
+	assert.Equal(t, 2, calls)
+}
+
+func TestCachedCheck_TimedOut(t *testing.T) {
+	calls := 0
+	check := CachedCheck(time.Minute, func(ctx context.Context) error {
+		calls++
+		if calls == 1 {
+			<-ctx.Done()
+			return errors.Wrap(ctx.Err(), "looking up location")
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	assert.ErrorIs(t, check(ctx), context.DeadlineExceeded)
+
+	assert.NoError(t, check(context.Background()), "timed out check isn't cached")
+	assert.NoError(t, check(context.Background()))
+	assert.Equal(t, 2, calls)
+}
@@ -1,14 +1,18 @@
 package api
 
 import (
+	"encoding/json"
 	ierr "github.com/IakimenkoD/xm-companies-service/internal/errors"
+	"github.com/IakimenkoD/xm-companies-service/internal/model"
 	"github.com/IakimenkoD/xm-companies-service/internal/repository/dataprovider"
 	"github.com/go-chi/chi"
 	"github.com/pkg/errors"
 	"io"
+	"mime"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func getURLParam(r *http.Request, field string) (string, error) {
@@ -92,6 +96,54 @@ func getQueryStringSlice(r *http.Request, field string) ([]string, error) {
 	return vals, nil
 }
 
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+func getQueryUint64(r *http.Request, field string, defaultValue uint64) (uint64, error) {
+	param := r.URL.Query().Get(field)
+	if param == "" {
+		return defaultValue, nil
+	}
+
+	val, err := strconv.ParseUint(param, 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(ierr.InvalidParam, field)
+	}
+
+	return val, nil
+}
+
+func getQueryBool(r *http.Request, field string) (bool, error) {
+	param := r.URL.Query().Get(field)
+	if param == "" {
+		return false, nil
+	}
+
+	val, err := strconv.ParseBool(param)
+	if err != nil {
+		return false, errors.Wrap(ierr.InvalidParam, field)
+	}
+
+	return val, nil
+}
+
+// getQueryTime parses RFC 3339 time, zero time is returned if parameter is absent.
+func getQueryTime(r *http.Request, field string) (time.Time, error) {
+	param := r.URL.Query().Get(field)
+	if param == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, param)
+	if err != nil {
+		return time.Time{}, errors.Wrap(ierr.InvalidParam, field)
+	}
+
+	return t, nil
+}
+
 func parseCompaniesFilter(r *http.Request) (*dataprovider.CompanyFilter, error) {
 	ids, err := getQueryInt64Slice(r, "ids")
 	if err != nil {
@@ -127,13 +179,165 @@ func parseCompaniesFilter(r *http.Request) (*dataprovider.CompanyFilter, error)
 		phones[n] = normalizePhoneNumber(phones[n])
 	}
 
+	createdAfter, err := getQueryTime(r, "created_after")
+	if err != nil {
+		return nil, err
+	}
+
+	createdBefore, err := getQueryTime(r, "created_before")
+	if err != nil {
+		return nil, err
+	}
+
+	updatedSince, err := getQueryTime(r, "updated_since")
+	if err != nil {
+		return nil, err
+	}
+
+	includeDeleted, err := getQueryBool(r, "include_deleted")
+	if err != nil {
+		return nil, err
+	}
+
+	onlyDeleted, err := getQueryBool(r, "only_deleted")
+	if err != nil {
+		return nil, err
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	match := r.URL.Query().Get("match")
+	if match == "" {
+		match = dataprovider.MatchFullText
+	}
+	if !dataprovider.ValidMatch(match) {
+		return nil, errors.Wrap(ierr.InvalidParam, "match")
+	}
+
+	sort, err := dataprovider.ParseSort(r.URL.Query().Get("sort"))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range sort {
+		if f.Field == dataprovider.SortByRelevance && query == "" {
+			return nil, errors.Wrap(ierr.InvalidParam, "sort by relevance requires q")
+		}
+	}
+	if query != "" && len(sort) == 0 {
+		sort = []dataprovider.SortField{{Field: dataprovider.SortByRelevance, Desc: true}}
+	}
+
+	limit, err := getQueryUint64(r, "limit", defaultPageLimit)
+	if err != nil {
+		return nil, err
+	}
+	if limit == 0 || limit > maxPageLimit {
+		return nil, errors.Wrap(ierr.InvalidParam, "limit")
+	}
+
+	var cursor *dataprovider.Cursor
+	if token := r.URL.Query().Get("cursor"); token != "" {
+		if cursor, err = dataprovider.DecodeCursor(token, sort); err != nil {
+			return nil, err
+		}
+	}
+
 	return dataprovider.NewCompanyFilter().
 		ByIDs(ids...).
 		ByNames(names...).
 		ByCodes(codes...).
 		ByCountries(toLowerCase(countries)...).
 		ByWebsites(toLowerCase(websites)...).
-		ByPhones(phones...), nil
+		ByPhones(phones...).
+		ByCreatedAfter(createdAfter).
+		ByCreatedBefore(createdBefore).
+		ByUpdatedSince(updatedSince).
+		WithDeleted(includeDeleted).
+		ByDeleted(onlyDeleted).
+		ByQuery(query, match).
+		OrderBy(sort...).
+		WithLimit(limit).
+		After(cursor), nil
+}
+
+func parseRevisionsFilter(r *http.Request) (*dataprovider.RevisionFilter, error) {
+	limit, err := getQueryUint64(r, "limit", defaultPageLimit)
+	if err != nil {
+		return nil, err
+	}
+	if limit == 0 || limit > maxPageLimit {
+		return nil, errors.Wrap(ierr.InvalidParam, "limit")
+	}
+
+	var beforeID int64
+	if token := r.URL.Query().Get("cursor"); token != "" {
+		if beforeID, err = dataprovider.DecodeRevisionCursor(token); err != nil {
+			return nil, err
+		}
+	}
+
+	return dataprovider.NewRevisionFilter().
+		Before(beforeID).
+		WithLimit(limit), nil
+}
+
+func decodePatch(r *http.Request) (model.Patch, error) {
+	mediaType := "application/json"
+	if header := r.Header.Get("Content-Type"); header != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(header); err != nil {
+			return nil, errors.Wrap(ierr.UnsupportedType, header)
+		}
+	}
+
+	switch mediaType {
+	case model.MergePatchType, "application/json":
+		patch := model.MergePatch{}
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			return nil, errors.Wrap(ierr.WrongRequest, err.Error())
+		}
+		return patch, nil
+	case model.JSONPatchType:
+		patch := model.JSONPatch{}
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			return nil, errors.Wrap(ierr.WrongRequest, err.Error())
+		}
+		return patch, nil
+	default:
+		return nil, errors.Wrap(ierr.UnsupportedType, mediaType)
+	}
+}
+
+// etag returns strong entity tag of the company version.
+func etag(company *model.Company) string {
+	return `"` + strconv.FormatInt(company.Version, 10) + `"`
+}
+
+// getIfMatch returns company version required by If-Match header, 0 means any version.
+// Weak tags never match, the first strong tag is used if there are many.
+func (srv *Server) getIfMatch(r *http.Request) (int64, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	switch header {
+	case "":
+		if srv.cfg.API.RequireIfMatch {
+			return 0, ierr.VersionRequired
+		}
+		return 0, nil
+	case "*":
+		return 0, nil
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if strings.HasPrefix(tag, "W/") {
+			continue
+		}
+		version, err := strconv.ParseInt(strings.Trim(tag, `"`), 10, 64)
+		if err == nil && version > 0 {
+			return version, nil
+		}
+	}
+
+	return 0, ierr.VersionMismatch
 }
 
 func normalizePhoneNumber(phoneNumber string) string {
@@ -151,16 +355,73 @@ func normalizePhoneNumber(phoneNumber string) string {
 }
 
 func respondError(w http.ResponseWriter, err error) {
+	http.Error(w, err.Error(), errorStatus(err))
+}
+
+func errorStatus(err error) int {
 	switch {
-	case errors.Is(err, ierr.CompanyNotFound):
-		http.Error(w, err.Error(), http.StatusNotFound)
-		return
+	case errors.Is(err, ierr.CompanyNotFound), errors.Is(err, ierr.UserNotFound):
+		return http.StatusNotFound
 	case errors.Is(err, ierr.InvalidParam), errors.Is(err, ierr.WrongRequest), errors.Is(err, io.EOF), errors.Is(err, ierr.CompanyExists):
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		return http.StatusBadRequest
+	case errors.Is(err, ierr.UserExists):
+		return http.StatusConflict
+	case errors.Is(err, ierr.InvalidCredentials):
+		return http.StatusUnauthorized
+	case errors.Is(err, ierr.VersionMismatch):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, ierr.VersionRequired):
+		return http.StatusPreconditionRequired
+	case errors.Is(err, ierr.PatchTestFailed):
+		return http.StatusConflict
+	case errors.Is(err, ierr.UnsupportedType):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, ierr.BatchAborted):
+		return http.StatusFailedDependency
 	default:
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return http.StatusInternalServerError
+	}
+}
+
+// decodeBatch reads batch request, companies are required and limited by api.max_batch_size.
+func (srv *Server) decodeBatch(r *http.Request) (*batchRequest, error) {
+	req := &batchRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return nil, errors.Wrap(ierr.WrongRequest, err.Error())
+	}
+	if len(req.Companies) == 0 {
+		return nil, errors.Wrap(ierr.InvalidParam, "companies")
+	}
+	if len(req.Companies) > srv.cfg.API.MaxBatchSize {
+		return nil, errors.Wrapf(ierr.InvalidParam, "more than %d companies", srv.cfg.API.MaxBatchSize)
+	}
+	return req, nil
+}
+
+// respondBatch reports result of every company, the response status is 200 if all companies
+// succeeded, otherwise 422 for atomic batch, nothing is changed then, and 207 for the rest.
+func respondBatch(w http.ResponseWriter, req *batchRequest, errs []error, status int) {
+	resp := batchResponse{Results: make([]batchResult, 0, len(errs))}
+	code := http.StatusOK
+	for n, err := range errs {
+		result := batchResult{Index: n, Status: status}
+		if err == nil {
+			result.ID = req.Companies[n].ID
+		} else {
+			result.Status = errorStatus(err)
+			result.Error = err.Error()
+
+			code = http.StatusMultiStatus
+			if req.atomic() {
+				code = http.StatusUnprocessableEntity
+			}
+		}
+		resp.Results = append(resp.Results, result)
+	}
+
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		respondError(w, err)
 	}
 }
 
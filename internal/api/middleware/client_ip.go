@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"github.com/IakimenkoD/xm-companies-service/internal/model"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// IPResolver finds client address of requests passed through trusted proxies.
+type IPResolver struct {
+	trustedProxies []*net.IPNet
+}
+
+func NewIPResolver(trustedProxies []string) (*IPResolver, error) {
+	nets, err := parseCIDRs(trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return &IPResolver{trustedProxies: nets}, nil
+}
+
+// ClientIP stores client address resolved by resolver in request context.
+func ClientIP(resolver *IPResolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := model.ContextWithClientIP(r.Context(), resolver.Resolve(r))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Resolve returns address of the peer unless it's a trusted proxy, then addresses of Forwarded
+// or, if it's absent, X-Forwarded-For headers are walked right to left skipping trusted proxies.
+// The first untrusted address is the client, X-Real-Ip is used only if neither header is set.
+func (res *IPResolver) Resolve(r *http.Request) string {
+	peer := parseHost(r.RemoteAddr)
+	if peer == nil {
+		return r.RemoteAddr
+	}
+	if !res.trusted(peer) {
+		return peer.String()
+	}
+
+	var hops []string
+	if forwarded := r.Header.Values("Forwarded"); len(forwarded) > 0 {
+		hops = forwardedFor(forwarded)
+	} else if forwardedFor := r.Header.Values("X-Forwarded-For"); len(forwardedFor) > 0 {
+		hops = splitList(forwardedFor)
+	} else if realIP := parseHost(r.Header.Get("X-Real-Ip")); realIP != nil {
+		return realIP.String()
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0 && res.trusted(client); i-- {
+		ip := parseHost(hops[i])
+		if ip == nil {
+			// obfuscated or malformed hop, nothing behind it can be trusted
+			break
+		}
+		client = ip
+	}
+
+	return client.String()
+}
+
+func (res *IPResolver) trusted(ip net.IP) bool {
+	return containsIP(res.trustedProxies, ip)
+}
+
+// forwardedFor returns "for" parameters of RFC 7239 Forwarded header elements.
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, element := range splitList(values) {
+		for _, pair := range strings.Split(element, ";") {
+			name, value, ok := cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(name, "for") {
+				hops = append(hops, strings.Trim(value, `"`))
+			}
+		}
+	}
+	return hops
+}
+
+func splitList(values []string) []string {
+	var items []string
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			items = append(items, strings.TrimSpace(item))
+		}
+	}
+	return items
+}
+
+// parseHost parses address with optional port, IPv6 address may be enclosed in brackets.
+func parseHost(addr string) net.IP {
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return net.ParseIP(host)
+	}
+	return net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]"))
+}
+
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
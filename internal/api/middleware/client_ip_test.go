@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPResolver_Resolve(t *testing.T) {
+	resolver, err := NewIPResolver([]string{"10.0.0.0/8", "fd00::/8"})
+	require.NoError(t, err)
+
+	tt := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string][]string
+		expected   string
+	}{
+		{
+			name:       "untrusted peer",
+			remoteAddr: "1.1.1.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"2.2.2.2"}, "Forwarded": {"for=2.2.2.2"}},
+			expected:   "1.1.1.1",
+		},
+		{
+			name:       "trusted peer without headers",
+			remoteAddr: "10.0.0.1:1234",
+			expected:   "10.0.0.1",
+		},
+		{
+			name:       "ipv6 peer",
+			remoteAddr: "[fd00::1]:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"2001:db8::1"}},
+			expected:   "2001:db8::1",
+		},
+		{
+			name:       "malformed remote address",
+			remoteAddr: "pipe",
+			headers:    map[string][]string{"X-Forwarded-For": {"2.2.2.2"}},
+			expected:   "pipe",
+		},
+		{
+			name:       "x-forwarded-for",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"2.2.2.2"}},
+			expected:   "2.2.2.2",
+		},
+		{
+			name:       "x-forwarded-for chain of trusted proxies",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"2.2.2.2, 10.0.0.3", "10.0.0.2"}},
+			expected:   "2.2.2.2",
+		},
+		{
+			name:       "x-forwarded-for hops before untrusted one are ignored",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"6.6.6.6, 2.2.2.2, 10.0.0.2"}},
+			expected:   "2.2.2.2",
+		},
+		{
+			name:       "x-forwarded-for of trusted proxies only",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}},
+			expected:   "10.0.0.3",
+		},
+		{
+			name:       "x-forwarded-for with port",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"2.2.2.2:5678"}},
+			expected:   "2.2.2.2",
+		},
+		{
+			name:       "malformed x-forwarded-for hop stops the walk",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"2.2.2.2, garbage, 10.0.0.2"}},
+			expected:   "10.0.0.2",
+		},
+		{
+			name:       "empty x-forwarded-for",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {""}},
+			expected:   "10.0.0.1",
+		},
+		{
+			name:       "forwarded ipv6 with port",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"Forwarded": {`for="[2001:db8::1]:443"`}},
+			expected:   "2001:db8::1",
+		},
+		{
+			name:       "forwarded ipv6 without port",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"Forwarded": {`for="[2001:db8::1]"`}},
+			expected:   "2001:db8::1",
+		},
+		{
+			name:       "forwarded chain with parameters",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"Forwarded": {"for=6.6.6.6, For=2.2.2.2;proto=https;by=10.0.0.2", "proto=http; for=10.0.0.2"}},
+			expected:   "2.2.2.2",
+		},
+		{
+			name:       "forwarded is preferred to x-forwarded-for",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"Forwarded": {"for=2.2.2.2"}, "X-Forwarded-For": {"3.3.3.3"}},
+			expected:   "2.2.2.2",
+		},
+		{
+			name:       "obfuscated forwarded hop stops the walk",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"Forwarded": {"for=2.2.2.2, for=_hidden"}},
+			expected:   "10.0.0.1",
+		},
+		{
+			name:       "forwarded without for parameter",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"Forwarded": {"proto=https;by=10.0.0.2"}},
+			expected:   "10.0.0.1",
+		},
+		{
+			name:       "malformed forwarded",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"Forwarded": {`for="2.2.2.2`}},
+			expected:   "2.2.2.2",
+		},
+		{
+			name:       "x-real-ip",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Real-Ip": {"2.2.2.2"}},
+			expected:   "2.2.2.2",
+		},
+		{
+			name:       "x-real-ip is ignored if x-forwarded-for is set",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Real-Ip": {"2.2.2.2"}, "X-Forwarded-For": {"3.3.3.3"}},
+			expected:   "3.3.3.3",
+		},
+		{
+			name:       "malformed x-real-ip",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Real-Ip": {"garbage"}},
+			expected:   "10.0.0.1",
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tc.remoteAddr
+			for name, values := range tc.headers {
+				for _, v := range values {
+					r.Header.Add(name, v)
+				}
+			}
+
+			assert.Equal(t, tc.expected, resolver.Resolve(r))
+		})
+	}
+}
+
+func TestNewIPResolver_InvalidCIDR(t *testing.T) {
+	_, err := NewIPResolver([]string{"10.0.0.0"})
+	assert.Error(t, err)
+}
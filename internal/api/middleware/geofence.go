@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"context"
+	"github.com/IakimenkoD/xm-companies-service/internal/config"
+	ierr "github.com/IakimenkoD/xm-companies-service/internal/errors"
+	"github.com/IakimenkoD/xm-companies-service/internal/service"
+	"github.com/pkg/errors"
+	"net"
+	"strings"
+)
+
+// GeofencePolicy holds geofence rules parsed from config.
+type GeofencePolicy struct {
+	defaultRule *GeofenceRule
+	routes      map[string]*GeofenceRule
+}
+
+func NewGeofencePolicy(conf config.Geofence) (*GeofencePolicy, error) {
+	defaultRule, err := newGeofenceRule(conf.Default)
+	if err != nil {
+		return nil, errors.Wrap(err, "default geofence rule")
+	}
+
+	p := &GeofencePolicy{
+		defaultRule: defaultRule,
+		routes:      make(map[string]*GeofenceRule, len(conf.Routes)),
+	}
+	for route, rule := range conf.Routes {
+		if !isGeofenceRoute(route) {
+			return nil, errors.Errorf("unknown geofence route %s, expected one of %s",
+				route, strings.Join(config.GeofenceRoutes, ", "))
+		}
+		if p.routes[route], err = newGeofenceRule(rule); err != nil {
+			return nil, errors.Wrapf(err, "geofence rule of route %s", route)
+		}
+	}
+
+	return p, nil
+}
+
+func isGeofenceRoute(route string) bool {
+	for _, known := range config.GeofenceRoutes {
+		if route == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Rule returns rule of the route, default rule is returned if the route has no own one.
+func (p *GeofencePolicy) Rule(route string) *GeofenceRule {
+	if rule, ok := p.routes[route]; ok {
+		return rule
+	}
+	return p.defaultRule
+}
+
+type GeofenceRule struct {
+	allowedCountries map[string]bool
+	deniedCountries  map[string]bool
+	allowedNets      []*net.IPNet
+	deniedNets       []*net.IPNet
+	failOpen         bool
+}
+
+func newGeofenceRule(conf config.GeofenceRule) (rule *GeofenceRule, err error) {
+	rule = &GeofenceRule{
+		allowedCountries: countriesSet(conf.AllowedCountries),
+		deniedCountries:  countriesSet(conf.DeniedCountries),
+		failOpen:         conf.FailOpen,
+	}
+	if rule.allowedNets, err = parseCIDRs(conf.AllowedCIDRs); err != nil {
+		return nil, err
+	}
+	if rule.deniedNets, err = parseCIDRs(conf.DeniedCIDRs); err != nil {
+		return nil, err
+	}
+
+	return rule, nil
+}
+
+// Allows checks client ip against the rule, location is looked up only if ip doesn't belong to listed networks.
+// Error of location lookup is returned only if the rule fails closed.
+func (r *GeofenceRule) Allows(ctx context.Context, ipChecker service.IpChecker, ip string) (bool, error) {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		if containsIP(r.deniedNets, parsed) {
+			return false, nil
+		}
+		if containsIP(r.allowedNets, parsed) {
+			return true, nil
+		}
+	}
+
+	if len(r.allowedCountries) == 0 && len(r.deniedCountries) == 0 {
+		return true, nil
+	}
+
+	location, err := ipChecker.GetUserLocation(ctx, ip)
+	switch {
+	case errors.Is(err, ierr.UnknownLocation):
+		location = ""
+	case err != nil && r.failOpen:
+		return true, nil
+	case err != nil:
+		return false, err
+	}
+	location = strings.ToUpper(location)
+
+	if r.deniedCountries[location] {
+		return false, nil
+	}
+	return len(r.allowedCountries) == 0 || r.allowedCountries[location], nil
+}
+
+func countriesSet(countries []string) map[string]bool {
+	set := make(map[string]bool, len(countries))
+	for _, c := range countries {
+		set[strings.ToUpper(strings.TrimSpace(c))] = true
+	}
+	return set
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing cidr %q", cidr)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
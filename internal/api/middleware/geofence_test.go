@@ -0,0 +1,152 @@
+package middleware
+
+import (
+	"context"
+	"github.com/IakimenkoD/xm-companies-service/internal/config"
+	ierr "github.com/IakimenkoD/xm-companies-service/internal/errors"
+	"github.com/IakimenkoD/xm-companies-service/internal/service"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+var errProviderDown = errors.New("provider is down")
+
+// stubLocations resolves 1.1.1.1 to CY, 2.2.2.2 to RU, fails for 3.3.3.3, others are unknown.
+func stubLocations(t *testing.T) service.IpChecker {
+	return service.NewIpCheckerMock(t).GetUserLocationMock.Set(func(_ context.Context, ip string) (string, error) {
+		switch ip {
+		case "1.1.1.1":
+			return "cy", nil
+		case "2.2.2.2":
+			return "RU", nil
+		case "3.3.3.3":
+			return "", errProviderDown
+		default:
+			return "", ierr.UnknownLocation
+		}
+	})
+}
+
+func TestGeofenceRule_Allows(t *testing.T) {
+	tt := []struct {
+		name    string
+		rule    config.GeofenceRule
+		ip      string
+		allowed bool
+		err     error
+	}{
+		{
+			name:    "allowed country",
+			rule:    config.GeofenceRule{AllowedCountries: []string{"CY"}},
+			ip:      "1.1.1.1",
+			allowed: true,
+		},
+		{
+			name: "fail: country isn't allowed",
+			rule: config.GeofenceRule{AllowedCountries: []string{"CY"}},
+			ip:   "2.2.2.2",
+		},
+		{
+			name: "fail: unknown location isn't allowed",
+			rule: config.GeofenceRule{AllowedCountries: []string{"CY"}},
+			ip:   "4.4.4.4",
+		},
+		{
+			name:    "empty allowlist allows countries not denied",
+			rule:    config.GeofenceRule{DeniedCountries: []string{"ru"}},
+			ip:      "1.1.1.1",
+			allowed: true,
+		},
+		{
+			name: "fail: denied country",
+			rule: config.GeofenceRule{DeniedCountries: []string{"ru"}},
+			ip:   "2.2.2.2",
+		},
+		{
+			name: "fail: denied country overrides allowed one",
+			rule: config.GeofenceRule{AllowedCountries: []string{"RU"}, DeniedCountries: []string{"RU"}},
+			ip:   "2.2.2.2",
+		},
+		{
+			name:    "empty rule allows any location",
+			ip:      "3.3.3.3",
+			allowed: true,
+		},
+		{
+			name:    "allowed network skips location lookup",
+			rule:    config.GeofenceRule{AllowedCountries: []string{"CY"}, AllowedCIDRs: []string{"2.2.0.0/16"}},
+			ip:      "2.2.2.2",
+			allowed: true,
+		},
+		{
+			name: "fail: denied network",
+			rule: config.GeofenceRule{AllowedCountries: []string{"CY"}, DeniedCIDRs: []string{"1.1.1.0/24"}},
+			ip:   "1.1.1.1",
+		},
+		{
+			name: "fail: denied network overrides allowed one",
+			rule: config.GeofenceRule{AllowedCIDRs: []string{"1.1.0.0/16"}, DeniedCIDRs: []string{"1.1.1.0/24"}},
+			ip:   "1.1.1.1",
+		},
+		{
+			name: "fail: provider failure fails closed",
+			rule: config.GeofenceRule{AllowedCountries: []string{"CY"}},
+			ip:   "3.3.3.3",
+			err:  errProviderDown,
+		},
+		{
+			name:    "fail open lets request through on provider failure",
+			rule:    config.GeofenceRule{AllowedCountries: []string{"CY"}, FailOpen: true},
+			ip:      "3.3.3.3",
+			allowed: true,
+		},
+		{
+			name: "fail: fail open doesn't allow unknown location",
+			rule: config.GeofenceRule{AllowedCountries: []string{"CY"}, FailOpen: true},
+			ip:   "4.4.4.4",
+		},
+		{
+			name: "fail: fail open doesn't allow denied network",
+			rule: config.GeofenceRule{DeniedCIDRs: []string{"3.3.3.0/24"}, FailOpen: true},
+			ip:   "3.3.3.3",
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			rule, err := newGeofenceRule(tc.rule)
+			require.NoError(t, err)
+
+			allowed, err := rule.Allows(context.Background(), stubLocations(t), tc.ip)
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.allowed, allowed)
+		})
+	}
+}
+
+func TestGeofencePolicy_Rule(t *testing.T) {
+	policy, err := NewGeofencePolicy(config.Geofence{
+		Default: config.GeofenceRule{AllowedCountries: []string{"CY"}},
+		Routes: map[string]config.GeofenceRule{
+			"delete_company": {AllowedCountries: []string{"RU"}},
+		},
+	})
+	require.NoError(t, err)
+
+	allowed, err := policy.Rule("create_company").Allows(context.Background(), stubLocations(t), "2.2.2.2")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	allowed, err = policy.Rule("delete_company").Allows(context.Background(), stubLocations(t), "2.2.2.2")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+	_, err = NewGeofencePolicy(config.Geofence{Default: config.GeofenceRule{DeniedCIDRs: []string{"1.1.1.1"}}})
+	assert.Error(t, err)
+
+	_, err = NewGeofencePolicy(config.Geofence{Routes: map[string]config.GeofenceRule{"remove_company": {}}})
+	assert.EqualError(t, err, "unknown geofence route remove_company, expected one of create_company, delete_company, restore_company")
+}
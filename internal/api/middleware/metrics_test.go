@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"github.com/IakimenkoD/xm-companies-service/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetrics_MethodLabel(t *testing.T) {
+	tt := []struct {
+		method   string
+		expected string
+	}{
+		{method: http.MethodGet, expected: http.MethodGet},
+		{method: http.MethodDelete, expected: http.MethodDelete},
+		{method: "PROPFIND", expected: otherMethod},
+		{method: "get", expected: otherMethod},
+	}
+
+	// the handler is called without router, so requests are labeled by unmatched route
+	handler := Metrics(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	for _, tc := range tt {
+		counter := metrics.HTTPRequests.WithLabelValues(tc.expected, unmatchedRoute, "200")
+		before := testutil.ToFloat64(counter)
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tc.method, "/companies", nil))
+
+		assert.Equal(t, before+1, testutil.ToFloat64(counter), tc.method)
+	}
+}
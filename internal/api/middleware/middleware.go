@@ -1,20 +1,17 @@
 package middleware
 
 import (
+	ierr "github.com/IakimenkoD/xm-companies-service/internal/errors"
 	"github.com/IakimenkoD/xm-companies-service/internal/model"
 	"github.com/IakimenkoD/xm-companies-service/internal/service"
-	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
 	"net"
 	"net/http"
 	"strings"
 )
 
-const (
-	allowedLocation = "CY"
-	testingToken    = "dGVzdCBjYXNlIHJlcXVpcmVkIHRva2Vu"
-)
-
-func CheckAuth(jwtKey []byte) func(http.Handler) http.Handler {
+// CheckAuth authenticates request token, claims of the token owner are stored in request context.
+func CheckAuth(authenticator service.Authenticator) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			ctx := r.Context()
@@ -24,36 +21,49 @@ func CheckAuth(jwtKey []byte) func(http.Handler) http.Handler {
 				w.WriteHeader(http.StatusUnauthorized)
 				return
 			}
-			// only for test case purposes
-			// TODO should be replaced with something else
-			if token == testingToken {
-				r = r.WithContext(ctx)
-				next.ServeHTTP(w, r)
-				return
-			}
 
-			claims := &model.Claims{}
-			tkn, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-				return jwtKey, nil
-			})
-			if err != nil {
-				if err == jwt.ErrSignatureInvalid {
-					w.WriteHeader(http.StatusUnauthorized)
-					return
-				}
+			claims, err := authenticator.Authenticate(ctx, token)
+			switch {
+			case errors.Is(err, ierr.InvalidToken):
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			case errors.Is(err, ierr.WrongRequest):
 				w.WriteHeader(http.StatusBadRequest)
 				return
-			}
-			if !tkn.Valid {
-				w.WriteHeader(http.StatusUnauthorized)
+			case err != nil:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
+
+			ctx = model.ContextWithClaims(ctx, claims)
 			r = r.WithContext(ctx)
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
+// RequireScopes allows requests of users authenticated by CheckAuth, which token grants all of given scopes.
+func RequireScopes(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := model.ClaimsFromContext(r.Context())
+			if claims == nil {
+				http.Error(w, "permission denied", http.StatusForbidden)
+				return
+			}
+
+			for _, scope := range scopes {
+				if !claims.HasScope(scope) {
+					http.Error(w, "permission denied", http.StatusForbidden)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // getAuthToken gets token either from cookie or header
 func getAuthToken(r *http.Request) (string, error) {
 	tokens, ok := r.Header["Authorization"]
@@ -74,17 +84,18 @@ func getAuthToken(r *http.Request) (string, error) {
 	return "", nil
 }
 
-func CheckIPAddress(ipChecker service.IpChecker) func(http.Handler) http.Handler {
+// CheckIPAddress allows requests of clients passing geofence rule.
+func CheckIPAddress(ipChecker service.IpChecker, rule *GeofenceRule) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			ctx := r.Context()
 			ip := getUserIP(r)
-			location, err := ipChecker.GetUserLocation(ctx, ip)
+			allowed, err := rule.Allows(ctx, ipChecker, ip)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-			if location != allowedLocation {
+			if !allowed {
 				http.Error(w, "your location is not allowed", http.StatusForbidden)
 				return
 			}
@@ -94,17 +105,13 @@ func CheckIPAddress(ipChecker service.IpChecker) func(http.Handler) http.Handler
 	}
 }
 
+// getUserIP returns address resolved by ClientIP or address of the peer.
 func getUserIP(r *http.Request) string {
-	ipAddress := r.Header.Get("X-Real-Ip")
-	if ipAddress == "" {
-		ipAddress = r.Header.Get("X-Forwarded-For")
-	}
-	if ipAddress == "" {
-		ipAddress = r.RemoteAddr
+	if ip := model.ClientIPFromContext(r.Context()); ip != "" {
+		return ip
 	}
-	if len(strings.Split(ipAddress, ":")) > 1 {
-		ipAddress, _, _ = net.SplitHostPort(ipAddress)
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
 	}
-
-	return ipAddress
+	return r.RemoteAddr
 }
@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"github.com/IakimenkoD/xm-companies-service/internal/tracing"
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTracing(t *testing.T) {
+	const (
+		clientTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		clientSpanID  = "00f067aa0ba902b7"
+	)
+
+	tt := []struct {
+		name           string
+		path           string
+		traceparent    string
+		expectedName   string
+		expectedRoute  string
+		expectedStatus int
+		expectedCode   codes.Code
+	}{
+		{
+			name:           "span is named by route pattern",
+			path:           "/companies/1",
+			expectedName:   "GET /companies/{companyID}",
+			expectedRoute:  "/companies/{companyID}",
+			expectedStatus: http.StatusOK,
+			expectedCode:   codes.Unset,
+		},
+		{
+			name:           "client trace is continued",
+			path:           "/companies/1",
+			traceparent:    "00-" + clientTraceID + "-" + clientSpanID + "-01",
+			expectedName:   "GET /companies/{companyID}",
+			expectedRoute:  "/companies/{companyID}",
+			expectedStatus: http.StatusOK,
+			expectedCode:   codes.Unset,
+		},
+		{
+			name:           "failed request",
+			path:           "/companies/fail",
+			expectedName:   "GET /companies/{companyID}",
+			expectedRoute:  "/companies/{companyID}",
+			expectedStatus: http.StatusInternalServerError,
+			expectedCode:   codes.Error,
+		},
+		{
+			name:           "unknown path",
+			path:           "/unknown",
+			expectedName:   "GET " + unmatchedRoute,
+			expectedRoute:  unmatchedRoute,
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   codes.Error,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := tracing.Record()
+
+			var handlerSpan trace.SpanContext
+			r := chi.NewRouter()
+			r.Use(Tracing)
+			r.Get("/companies/{companyID}", func(w http.ResponseWriter, r *http.Request) {
+				handlerSpan = trace.SpanContextFromContext(r.Context())
+				if chi.URLParam(r, "companyID") == "fail" {
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+			})
+
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.traceparent != "" {
+				req.Header.Set("traceparent", tc.traceparent)
+			}
+			r.ServeHTTP(httptest.NewRecorder(), req)
+
+			spans := recorder.Ended()
+			require.Len(t, spans, 1, "a span per request")
+			span := spans[0]
+			assert.Equal(t, tc.expectedName, span.Name())
+			assert.Equal(t, trace.SpanKindServer, span.SpanKind())
+			assert.Contains(t, span.Attributes(), semconv.HTTPRouteKey.String(tc.expectedRoute))
+			assert.Contains(t, span.Attributes(), attribute.Int("http.status_code", tc.expectedStatus))
+			assert.Equal(t, tc.expectedCode, span.Status().Code)
+
+			if handlerSpan.IsValid() {
+				assert.Equal(t, span.SpanContext().SpanID(), handlerSpan.SpanID(), "handler runs in the request span")
+			}
+			if tc.traceparent != "" {
+				assert.Equal(t, clientTraceID, span.SpanContext().TraceID().String())
+				assert.Equal(t, clientSpanID, span.Parent().SpanID().String())
+			} else {
+				assert.False(t, span.Parent().IsValid(), "a new trace is started")
+			}
+		})
+	}
+}
@@ -4,62 +4,158 @@ import (
 	mw "github.com/IakimenkoD/xm-companies-service/internal/api/middleware"
 	"github.com/IakimenkoD/xm-companies-service/internal/config"
 	"github.com/IakimenkoD/xm-companies-service/internal/controller"
+	"github.com/IakimenkoD/xm-companies-service/internal/metrics"
+	"github.com/IakimenkoD/xm-companies-service/internal/model"
 	"github.com/IakimenkoD/xm-companies-service/internal/service"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"net/http"
 )
 
+// Batch routes follow "collection:method" notation, e.g. POST /api/v1/companies:batchCreate.
+const (
+	batchCreateURL = "/api/v1/companies:batchCreate"
+	batchUpdateURL = "/api/v1/companies:batchUpdate"
+	batchDeleteURL = "/api/v1/companies:batchDelete"
+)
+
 type Server struct {
 	*http.Server
 	controller controller.CompaniesService
+	users      controller.UsersService
+	sessions   controller.SessionsService
+	signer     service.TokenSigner
+	auth       service.Authenticator
 	ipChecker  service.IpChecker
+	geofence   *mw.GeofencePolicy
+	checks     []HealthCheck
 	cfg        *config.Config
 }
 
 func NewServer(
 	cfg *config.Config,
 	controller controller.CompaniesService,
+	users controller.UsersService,
+	sessions controller.SessionsService,
+	signer service.TokenSigner,
+	auth service.Authenticator,
 	ipChecker service.IpChecker,
-
+	checks ...HealthCheck,
 ) (*Server, error) {
+	geofence, err := mw.NewGeofencePolicy(cfg.Geofence)
+	if err != nil {
+		return nil, err
+	}
+	ipResolver, err := mw.NewIPResolver(cfg.API.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
 	srv := &Server{
 		Server: &http.Server{
 			Addr:         cfg.API.Address,
 			ReadTimeout:  cfg.API.ReadTimeout,
 			WriteTimeout: cfg.API.WriteTimeout,
+			ConnContext:  withConn,
 		},
 		cfg:        cfg,
 		controller: controller,
+		users:      users,
+		sessions:   sessions,
+		signer:     signer,
+		auth:       auth,
 		ipChecker:  ipChecker,
+		geofence:   geofence,
+		checks:     checks,
 	}
 
 	r := chi.NewRouter()
 
-	r.Use(middleware.RealIP)
+	r.Use(mw.Tracing)
+	r.Use(mw.Metrics)
+	r.Use(mw.ClientIP(ipResolver))
 	r.Use(middleware.Recoverer)
 
+	r.Get("/.well-known/jwks.json", srv.jwks)
+	r.Handle("/metrics", metrics.Handler())
+
 	r.Route("/internal", func(r chi.Router) {
 		r.Post("/signin", srv.signIn)
-		r.Get("/health", srv.health)
+		r.Post("/refresh", srv.refresh)
+		r.With(mw.CheckAuth(srv.auth)).Post("/signout", srv.signOut)
+		r.Get("/live", srv.live)
+		r.Get("/ready", srv.ready)
+		// health is kept for probes configured before live and ready were added
+		r.Get("/health", srv.live)
 
+		r.Route("/users", func(r chi.Router) {
+			r.Use(mw.CheckAuth(srv.auth))
+			r.Use(mw.RequireScopes(model.ScopeAdmin))
+
+			r.Post("/", srv.createUser)
+			r.Post("/{userID}/disable", srv.disableUser)
+			r.Post("/{userID}/enable", srv.enableUser)
+			r.Post("/{userID}/password", srv.resetPassword)
+		})
 	})
 
+	r.With(
+		mw.CheckIPAddress(srv.ipChecker, srv.geofence.Rule(config.GeofenceRouteCreateCompany)),
+		mw.CheckAuth(srv.auth),
+		mw.RequireScopes(model.ScopeCompaniesWrite),
+	).Post(batchCreateURL, srv.batchCreateCompanies)
+	r.With(
+		mw.CheckAuth(srv.auth),
+		mw.RequireScopes(model.ScopeCompaniesWrite),
+	).Post(batchUpdateURL, srv.batchUpdateCompanies)
+	r.With(
+		mw.CheckIPAddress(srv.ipChecker, srv.geofence.Rule(config.GeofenceRouteDeleteCompany)),
+		mw.CheckAuth(srv.auth),
+		mw.RequireScopes(model.ScopeCompaniesDelete),
+	).Post(batchDeleteURL, srv.batchDeleteCompanies)
+
 	r.Route("/api/v1/companies", func(r chi.Router) {
-		r.Get("/", srv.getCompanies)
-		r.Route("/{companyID}", func(r chi.Router) {
-			r.Get("/", srv.getCompanyByID)
-			r.Put("/", srv.updateCompany)
-			r.Patch("/", srv.patchCompany)
+		r.Group(func(r chi.Router) {
+			r.Use(mw.CheckAuth(srv.auth))
+			r.Use(mw.RequireScopes(model.ScopeCompaniesRead))
+
+			r.Get("/", srv.getCompanies)
+			r.Get("/export", srv.exportCompanies)
+			r.Get("/{companyID}", srv.getCompanyByID)
 		})
+		r.With(
+			mw.CheckAuth(srv.auth),
+			mw.RequireScopes(model.ScopeAdmin),
+		).Get("/{companyID}/history", srv.getCompanyHistory)
 
 		r.Group(func(r chi.Router) {
-			r.Use(mw.CheckIPAddress(srv.ipChecker))
-			r.Use(mw.CheckAuth(srv.cfg.API.JWTKey))
+			r.Use(mw.CheckAuth(srv.auth))
+			r.Use(mw.RequireScopes(model.ScopeCompaniesWrite))
 
-			r.Post("/", srv.createCompany)
-			r.Delete("/{companyID}", srv.deleteCompany)
+			r.Put("/{companyID}", srv.updateCompany)
+			r.Patch("/{companyID}", srv.patchCompany)
 		})
+
+		r.With(
+			mw.CheckIPAddress(srv.ipChecker, srv.geofence.Rule(config.GeofenceRouteCreateCompany)),
+			mw.CheckAuth(srv.auth),
+			mw.RequireScopes(model.ScopeCompaniesWrite),
+		).Post("/", srv.createCompany)
+		r.With(
+			mw.CheckIPAddress(srv.ipChecker, srv.geofence.Rule(config.GeofenceRouteCreateCompany)),
+			mw.CheckAuth(srv.auth),
+			mw.RequireScopes(model.ScopeCompaniesWrite),
+		).Post("/import", srv.importCompanies)
+		r.With(
+			mw.CheckIPAddress(srv.ipChecker, srv.geofence.Rule(config.GeofenceRouteDeleteCompany)),
+			mw.CheckAuth(srv.auth),
+			mw.RequireScopes(model.ScopeCompaniesDelete),
+		).Delete("/{companyID}", srv.deleteCompany)
+		r.With(
+			mw.CheckIPAddress(srv.ipChecker, srv.geofence.Rule(config.GeofenceRouteRestoreCompany)),
+			mw.CheckAuth(srv.auth),
+			mw.RequireScopes(model.ScopeCompaniesDelete),
+		).Post("/{companyID}/restore", srv.restoreCompany)
 	})
 
 	srv.Handler = r
@@ -0,0 +1,493 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	ierr "github.com/IakimenkoD/xm-companies-service/internal/errors"
+	"github.com/IakimenkoD/xm-companies-service/internal/model"
+	"github.com/pkg/errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats of exported and imported companies.
+const (
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+
+	csvType    = "text/csv"
+	ndjsonType = "application/x-ndjson"
+)
+
+// csvColumns are columns of exported companies, import uses name, code, country, website and phone only.
+var csvColumns = []string{"id", "name", "code", "country", "website", "phone", "created_at", "updated_at", "deleted_at", "version"}
+
+// csvFormulaPrefixes start cells which spreadsheets evaluate as formulas.
+const csvFormulaPrefixes = "=+-@\t\r"
+
+// maxNDJSONLine limits size of imported NDJSON line.
+const maxNDJSONLine = 1 << 20
+
+type importResponse struct {
+	DryRun bool `json:"dry_run"`
+	Rows   int  `json:"rows"`
+	// Accepted are companies created, or which would be created in dry run.
+	Accepted int           `json:"accepted"`
+	Failed   int           `json:"failed"`
+	Errors   []importError `json:"errors,omitempty"`
+}
+
+// importError describes failed row, rows are numbered by lines of the file starting from 1.
+type importError struct {
+	Row   int    `json:"row"`
+	Code  string `json:"code,omitempty"`
+	Error string `json:"error"`
+}
+
+// exportCompanies streams all companies matching the same filters as the companies list, limit is ignored.
+func (srv *Server) exportCompanies(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), srv.cfg.API.ExportTimeout)
+	defer cancel()
+	extendWriteDeadline(r, srv.cfg.API.ExportTimeout)
+
+	filter, err := parseCompaniesFilter(r)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	filter.WithLimit(maxPageLimit)
+
+	format, err := getFormat(r.URL.Query().Get("format"), "")
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	// the first page is read before response is started, so that its failure is reported with status
+	companies, next, err := srv.controller.GetCompanies(ctx, filter)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	enc := newCompanyEncoder(w, format)
+	w.Header().Set("Content-Type", enc.contentType())
+	w.Header().Set("Content-Disposition", `attachment; filename="companies.`+format+`"`)
+
+	flusher, _ := w.(http.Flusher)
+	for {
+		for _, company := range companies {
+			if err = enc.encode(company); err != nil {
+				abortResponse(w)
+				return
+			}
+		}
+		if err = enc.flush(); err != nil {
+			abortResponse(w)
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if next == nil {
+			return
+		}
+		filter.Cursor = next
+		if companies, next, err = srv.controller.GetCompanies(ctx, filter); err != nil {
+			abortResponse(w)
+			return
+		}
+	}
+}
+
+type connContextKey struct{}
+
+// withConn keeps connection in context of its requests, so that handlers streaming long responses
+// can extend its write deadline set by api.write_timeout.
+func withConn(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, conn)
+}
+
+// extendWriteDeadline lets the response be written within timeout instead of api.write_timeout.
+func extendWriteDeadline(r *http.Request, timeout time.Duration) {
+	if conn, ok := r.Context().Value(connContextKey{}).(net.Conn); ok {
+		_ = conn.SetWriteDeadline(time.Now().Add(timeout))
+	}
+}
+
+// abortResponse breaks connection of started response, so that client sees the response is incomplete.
+func abortResponse(w http.ResponseWriter) {
+	if hijacker, ok := w.(http.Hijacker); ok {
+		if conn, _, err := hijacker.Hijack(); err == nil {
+			_ = conn.Close()
+		}
+	}
+}
+
+// importCompanies creates valid companies of CSV or NDJSON body, format is taken from format parameter
+// or Content-Type header. Companies are created by batches of api.max_batch_size, dry_run only checks them.
+// Failed rows are reported in JSON or, if report=csv, in downloadable CSV.
+func (srv *Server) importCompanies(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	format, err := getFormat(r.URL.Query().Get("format"), contentType)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	dryRun, err := getQueryBool(r, "dry_run")
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	report := r.URL.Query().Get("report")
+	if report != "" && report != formatCSV {
+		respondError(w, errors.Wrap(ierr.InvalidParam, "report"))
+		return
+	}
+
+	dec, err := newCompanyDecoder(r.Body, format)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	resp := importResponse{DryRun: dryRun}
+	seen := make(map[string]bool)
+	batch := make([]*model.Company, 0, srv.cfg.API.MaxBatchSize)
+	rows := make([]int, 0, srv.cfg.API.MaxBatchSize)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		var errs []error
+		if dryRun {
+			errs, err = srv.controller.ValidateCompanies(ctx, batch)
+		} else {
+			errs, err = srv.controller.BatchCreateCompanies(ctx, batch, false)
+		}
+		if err != nil {
+			return err
+		}
+
+		for n, err := range errs {
+			if err != nil {
+				resp.addError(rows[n], batch[n].Code, err)
+				continue
+			}
+			resp.Accepted++
+		}
+
+		batch, rows = batch[:0], rows[:0]
+		return nil
+	}
+
+	for {
+		company, row, err := dec.decode()
+		if err == io.EOF {
+			break
+		}
+		resp.Rows++
+
+		switch {
+		case err != nil:
+			resp.addError(row, "", err)
+			continue
+		case seen[company.Code]:
+			// earlier rows aren't seen by dry run in storage
+			resp.addError(row, company.Code, ierr.CompanyExists)
+			continue
+		}
+		seen[company.Code] = true
+		company.Phone = normalizePhoneNumber(company.Phone)
+
+		batch = append(batch, company)
+		rows = append(rows, row)
+		if len(batch) < srv.cfg.API.MaxBatchSize {
+			continue
+		}
+		if err = flush(); err != nil {
+			respondError(w, err)
+			return
+		}
+	}
+	if err = flush(); err != nil {
+		respondError(w, err)
+		return
+	}
+
+	if report == formatCSV {
+		respondImportReport(w, resp.Errors)
+		return
+	}
+
+	if err = json.NewEncoder(w).Encode(resp); err != nil {
+		respondError(w, err)
+		return
+	}
+}
+
+func (resp *importResponse) addError(row int, code string, err error) {
+	resp.Failed++
+	resp.Errors = append(resp.Errors, importError{Row: row, Code: code, Error: err.Error()})
+}
+
+func respondImportReport(w http.ResponseWriter, errs []importError) {
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	_ = cw.Write([]string{"row", "code", "error"})
+	for _, e := range errs {
+		_ = cw.Write([]string{strconv.Itoa(e.Row), escapeCSVCell(e.Code), escapeCSVCell(e.Error)})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		respondError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", csvType)
+	w.Header().Set("Content-Disposition", `attachment; filename="import-errors.csv"`)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// getFormat returns format given by parameter or media type, csv is default.
+func getFormat(param, contentType string) (string, error) {
+	switch {
+	case param == formatCSV, param == "" && contentType == csvType:
+		return formatCSV, nil
+	case param == formatNDJSON, param == "" && contentType == ndjsonType:
+		return formatNDJSON, nil
+	case param == "":
+		return formatCSV, nil
+	default:
+		return "", errors.Wrap(ierr.InvalidParam, "format")
+	}
+}
+
+type companyEncoder interface {
+	contentType() string
+	encode(company *model.Company) error
+	flush() error
+}
+
+func newCompanyEncoder(w io.Writer, format string) companyEncoder {
+	if format == formatNDJSON {
+		return ndjsonEncoder{enc: json.NewEncoder(w)}
+	}
+	return &csvEncoder{w: csv.NewWriter(w)}
+}
+
+type csvEncoder struct {
+	w          *csv.Writer
+	headerDone bool
+}
+
+func (e *csvEncoder) contentType() string {
+	return csvType
+}
+
+func (e *csvEncoder) encode(company *model.Company) error {
+	if err := e.header(); err != nil {
+		return err
+	}
+
+	return e.w.Write([]string{
+		strconv.FormatInt(company.ID, 10),
+		escapeCSVCell(company.Name),
+		escapeCSVCell(company.Code),
+		escapeCSVCell(company.Country),
+		escapeCSVCell(company.Website),
+		escapeCSVCell(company.Phone),
+		company.CreatedAt.Format(time.RFC3339Nano),
+		formatTime(company.UpdatedAt),
+		formatTime(company.DeletedAt),
+		strconv.FormatInt(company.Version, 10),
+	})
+}
+
+func (e *csvEncoder) flush() error {
+	// header is written for empty export as well
+	if err := e.header(); err != nil {
+		return err
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvEncoder) header() error {
+	if e.headerDone {
+		return nil
+	}
+	e.headerDone = true
+	return e.w.Write(csvColumns)
+}
+
+// escapeCSVCell prefixes user-controlled cell looking like a formula with ', so that spreadsheet
+// opening exported file shows it as text instead of evaluating it (CSV injection).
+func escapeCSVCell(s string) string {
+	if s != "" && strings.ContainsRune(csvFormulaPrefixes, rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// unescapeCSVCell reverts escapeCSVCell, so that exported file is imported with original values.
+func unescapeCSVCell(s string) string {
+	if len(s) > 1 && s[0] == '\'' && strings.ContainsRune(csvFormulaPrefixes, rune(s[1])) {
+		return s[1:]
+	}
+	return s
+}
+
+type ndjsonEncoder struct {
+	enc *json.Encoder
+}
+
+func (e ndjsonEncoder) contentType() string {
+	return ndjsonType
+}
+
+func (e ndjsonEncoder) encode(company *model.Company) error {
+	return e.enc.Encode(company)
+}
+
+func (e ndjsonEncoder) flush() error {
+	return nil
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// companyDecoder returns imported companies with numbers of their rows, io.EOF is returned at the end.
+// Malformed row is returned as error, decoding may be continued.
+type companyDecoder interface {
+	decode() (*model.Company, int, error)
+}
+
+func newCompanyDecoder(r io.Reader, format string) (companyDecoder, error) {
+	if format == formatNDJSON {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxNDJSONLine)
+		return &ndjsonDecoder{scanner: scanner}, nil
+	}
+
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, errors.Wrap(ierr.WrongRequest, "reading CSV header: "+err.Error())
+	}
+
+	columns := make(map[string]int, len(header))
+	for n, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = n
+	}
+	for _, name := range []string{"name", "code", "country", "website", "phone"} {
+		if _, ok := columns[name]; !ok {
+			return nil, errors.Wrap(ierr.WrongRequest, "missing CSV column "+name)
+		}
+	}
+
+	return &csvDecoder{r: cr, columns: columns}, nil
+}
+
+type csvDecoder struct {
+	r       *csv.Reader
+	columns map[string]int
+	done    bool
+}
+
+func (d *csvDecoder) decode() (*model.Company, int, error) {
+	if d.done {
+		return nil, 0, io.EOF
+	}
+
+	record, err := d.r.Read()
+	if err == io.EOF {
+		return nil, 0, err
+	}
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) && parseErr.Err != nil {
+			return nil, parseErr.StartLine, errors.Wrap(ierr.WrongRequest, err.Error())
+		}
+		// the rest of body can't be read
+		d.done = true
+		line, _ := d.r.FieldPos(0)
+		return nil, line + 1, errors.Wrap(ierr.WrongRequest, err.Error())
+	}
+	row, _ := d.r.FieldPos(0)
+
+	field := func(name string) string {
+		if n, ok := d.columns[name]; ok && n < len(record) {
+			return unescapeCSVCell(record[n])
+		}
+		return ""
+	}
+	company := &model.Company{
+		Name:    field("name"),
+		Code:    field("code"),
+		Country: field("country"),
+		Website: field("website"),
+		Phone:   field("phone"),
+	}
+
+	return company, row, nil
+}
+
+type ndjsonDecoder struct {
+	scanner *bufio.Scanner
+	line    int
+	done    bool
+}
+
+func (d *ndjsonDecoder) decode() (*model.Company, int, error) {
+	for !d.done && d.scanner.Scan() {
+		d.line++
+		line := bytes.TrimSpace(d.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		imported := &model.Company{}
+		if err := json.Unmarshal(line, imported); err != nil {
+			return nil, d.line, errors.Wrap(ierr.WrongRequest, err.Error())
+		}
+
+		// only fields given by client are imported
+		company := &model.Company{
+			Name:    imported.Name,
+			Code:    imported.Code,
+			Country: imported.Country,
+			Website: imported.Website,
+			Phone:   imported.Phone,
+		}
+		return company, d.line, nil
+	}
+
+	if err := d.scanner.Err(); err != nil && !d.done {
+		// the rest of body can't be read, the line is reported as failed
+		d.done = true
+		d.line++
+		return nil, d.line, errors.Wrap(ierr.WrongRequest, err.Error())
+	}
+	return nil, 0, io.EOF
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"github.com/IakimenkoD/xm-companies-service/internal/config"
+	"github.com/IakimenkoD/xm-companies-service/internal/controller"
+	"github.com/IakimenkoD/xm-companies-service/internal/model"
+	"github.com/IakimenkoD/xm-companies-service/internal/repository/dataprovider"
+	"github.com/IakimenkoD/xm-companies-service/internal/service/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCSVEncoder_EscapesFormulas(t *testing.T) {
+	company := &model.Company{
+		ID:        1,
+		Name:      `=HYPERLINK("http://evil.example","click")`,
+		Code:      "@SUM(A1)",
+		Country:   "cy",
+		Website:   "-2+3",
+		Phone:     "+35799000000",
+		CreatedAt: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC),
+		Version:   1,
+	}
+
+	var buf bytes.Buffer
+	enc := newCompanyEncoder(&buf, formatCSV)
+	require.NoError(t, enc.encode(company))
+	require.NoError(t, enc.flush())
+
+	records, err := csv.NewReader(bytes.NewReader(buf.Bytes())).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, []string{
+		"1",
+		`'=HYPERLINK("http://evil.example","click")`,
+		"'@SUM(A1)",
+		"cy",
+		"'-2+3",
+		"'+35799000000",
+		"2026-10-01T00:00:00Z",
+		"",
+		"",
+		"1",
+	}, records[1])
+
+	// exported file is imported with original values
+	dec, err := newCompanyDecoder(bytes.NewReader(buf.Bytes()), formatCSV)
+	require.NoError(t, err)
+	imported, row, err := dec.decode()
+	require.NoError(t, err)
+	assert.Equal(t, 2, row)
+	assert.Equal(t, &model.Company{
+		Name:    company.Name,
+		Code:    company.Code,
+		Country: company.Country,
+		Website: company.Website,
+		Phone:   company.Phone,
+	}, imported)
+}
+
+func TestEscapeCSVCell(t *testing.T) {
+	tt := []struct {
+		cell     string
+		expected string
+	}{
+		{cell: "", expected: ""},
+		{cell: "acme", expected: "acme"},
+		{cell: "'quoted", expected: "'quoted"},
+		{cell: "a=b", expected: "a=b"},
+		{cell: "=1+2", expected: "'=1+2"},
+		{cell: "+1", expected: "'+1"},
+		{cell: "-1", expected: "'-1"},
+		{cell: "@cmd", expected: "'@cmd"},
+		{cell: "\t=1", expected: "'\t=1"},
+		{cell: "\r=1", expected: "'\r=1"},
+	}
+
+	for _, tc := range tt {
+		assert.Equal(t, tc.expected, escapeCSVCell(tc.cell), "%q", tc.cell)
+		assert.Equal(t, tc.cell, unescapeCSVCell(escapeCSVCell(tc.cell)), "%q", tc.cell)
+	}
+}
+
+func TestExportCompanies_OutlastsWriteTimeout(t *testing.T) {
+	cfg, err := config.New("", zap.NewNop())
+	require.NoError(t, err)
+	cfg.API.WriteTimeout = time.Millisecond * 100
+	cfg.API.ExportTimeout = time.Second * 5
+
+	pages := [][]*model.Company{
+		{{ID: 1, Name: "first", Code: "e1", Country: "cy"}},
+		{{ID: 2, Name: "second", Code: "e2", Country: "cy"}},
+	}
+	companies := controller.NewCompaniesServiceMock(t)
+	companies.GetCompaniesMock.Set(func(ctx context.Context, filter *dataprovider.CompanyFilter) ([]*model.Company, *dataprovider.Cursor, error) {
+		if filter.Cursor == nil {
+			return pages[0], &dataprovider.Cursor{Sort: "id", Values: []string{"1"}}, nil
+		}
+		// the second page is read after api.write_timeout passed
+		time.Sleep(cfg.API.WriteTimeout * 2)
+		return pages[1], nil, nil
+	})
+
+	srv, err := NewServer(cfg, companies, nil, nil, nil,
+		auth.NewStaticAuthenticator(map[string]string{viewerToken: model.RoleViewer}, nil), nil)
+	require.NoError(t, err)
+
+	h := httptest.NewUnstartedServer(srv.Handler)
+	h.Config.WriteTimeout = srv.WriteTimeout
+	h.Config.ConnContext = srv.ConnContext
+	h.Start()
+	defer h.Close()
+
+	req, err := http.NewRequest(http.MethodGet, h.URL+companiesURL+"/export?format=csv", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+viewerToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err, "export is cut off")
+	records, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, "e1", records[1][2])
+	assert.Equal(t, "e2", records[2][2])
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	ierr "github.com/IakimenkoD/xm-companies-service/internal/errors"
+	"github.com/IakimenkoD/xm-companies-service/internal/model"
+	"github.com/pkg/errors"
+	"net/http"
+	"strconv"
+)
+
+func (srv *Server) createUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	req := struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Role     string `json:"role"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, errors.Wrap(ierr.WrongRequest, err.Error()))
+		return
+	}
+
+	user := &model.User{
+		Username: req.Username,
+		Role:     req.Role,
+	}
+	id, err := srv.users.CreateUser(ctx, user, req.Password)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/internal/users/"+strconv.FormatInt(id, 10))
+	w.WriteHeader(http.StatusCreated)
+	if err = json.NewEncoder(w).Encode(user); err != nil {
+		respondError(w, err)
+		return
+	}
+}
+
+func (srv *Server) disableUser(w http.ResponseWriter, r *http.Request) {
+	srv.setUserDisabled(w, r, true)
+}
+
+func (srv *Server) enableUser(w http.ResponseWriter, r *http.Request) {
+	srv.setUserDisabled(w, r, false)
+}
+
+func (srv *Server) setUserDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	id, err := getURLInt64(r, "userID")
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	if err = srv.users.SetDisabled(r.Context(), id, disabled); err != nil {
+		respondError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (srv *Server) resetPassword(w http.ResponseWriter, r *http.Request) {
+	id, err := getURLInt64(r, "userID")
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	req := struct {
+		Password string `json:"password"`
+	}{}
+	if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, errors.Wrap(ierr.WrongRequest, err.Error()))
+		return
+	}
+
+	if err = srv.users.ResetPassword(r.Context(), id, req.Password); err != nil {
+		respondError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,417 @@
+package controller
+
+import (
+	"context"
+	ierr "github.com/IakimenkoD/xm-companies-service/internal/errors"
+	"github.com/IakimenkoD/xm-companies-service/internal/model"
+	"github.com/IakimenkoD/xm-companies-service/internal/repository/dataprovider"
+	"github.com/IakimenkoD/xm-companies-service/internal/tracing"
+	"github.com/pkg/errors"
+)
+
+func (c Controller) BatchCreateCompanies(ctx context.Context, companies []*model.Company, atomic bool) (errs []error, err error) {
+	ctx, span := tracing.Start(ctx, "Controller.BatchCreateCompanies")
+	defer func() { tracing.End(span, err) }()
+
+	errs, err = c.ValidateCompanies(ctx, companies)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		accepted := acceptBatch(companies, errs, atomic)
+		if len(accepted) == 0 {
+			return errs, nil
+		}
+
+		err = c.transactor.WithTx(ctx, func(ctx context.Context) error {
+			if err := c.companyStorage.InsertBatch(ctx, accepted); err != nil {
+				return err
+			}
+
+			created, err := c.getBatch(ctx, accepted)
+			if err != nil {
+				return err
+			}
+
+			return c.recordBatch(ctx, model.EventCompanyCreated, nil, created)
+		})
+		if !errors.Is(err, ierr.CompanyExists) {
+			return errs, err
+		}
+
+		// a company with the same code was created since companies were checked, it's committed
+		// by now, so checking codes again finds it and the rest are created without the rejected one
+		byCode := make(map[string]int, len(accepted))
+		for n, company := range companies {
+			if errs[n] == nil {
+				byCode[company.Code] = n
+			}
+		}
+		if cErr := c.checkDuplicates(ctx, byCode, nil, errs); cErr != nil {
+			return nil, cErr
+		}
+		if !anyRejected(byCode, errs) {
+			return errs, err
+		}
+	}
+}
+
+func (c Controller) ValidateCompanies(ctx context.Context, companies []*model.Company) (errs []error, err error) {
+	ctx, span := tracing.Start(ctx, "Controller.ValidateCompanies")
+	defer func() { tracing.End(span, err) }()
+
+	errs = make([]error, len(companies))
+	byCode := make(map[string]int, len(companies))
+	for n, company := range companies {
+		if company == nil {
+			errs[n] = ierr.WrongRequest
+			continue
+		}
+		if err := company.CheckFields(); err != nil {
+			errs[n] = err
+			continue
+		}
+		if _, ok := byCode[company.Code]; ok {
+			errs[n] = ierr.CompanyExists
+			continue
+		}
+		byCode[company.Code] = n
+	}
+
+	if err := c.checkDuplicates(ctx, byCode, nil, errs); err != nil {
+		return nil, err
+	}
+
+	return errs, nil
+}
+
+func (c Controller) BatchUpdateCompanies(ctx context.Context, companies []*model.Company, atomic bool) (errs []error, err error) {
+	ctx, span := tracing.Start(ctx, "Controller.BatchUpdateCompanies")
+	defer func() { tracing.End(span, err) }()
+
+	errs = c.checkBatchIDs(companies)
+	for n, company := range companies {
+		if errs[n] == nil {
+			errs[n] = company.CheckFields()
+		}
+	}
+
+	old, err := c.getOldBatch(ctx, companies, errs)
+	if err != nil {
+		return nil, err
+	}
+
+	byCode := make(map[string]int)
+	changed := make([]bool, len(companies))
+	for n, company := range companies {
+		if errs[n] != nil {
+			continue
+		}
+		before := old[company.ID]
+		if before.Equal(company) {
+			continue
+		}
+		changed[n] = true
+
+		if company.Code == before.Code {
+			continue
+		}
+		if _, ok := byCode[company.Code]; ok {
+			errs[n] = ierr.CompanyExists
+			continue
+		}
+		byCode[company.Code] = n
+	}
+
+	if err = c.checkDuplicates(ctx, byCode, companies, errs); err != nil {
+		return nil, err
+	}
+
+	for {
+		accepted := acceptBatch(companies, errs, atomic)
+		updates := make([]int, 0, len(accepted))
+		for n := range companies {
+			if errs[n] == nil && changed[n] {
+				updates = append(updates, n)
+			}
+		}
+		if len(accepted) == 0 || len(updates) == 0 {
+			return errs, nil
+		}
+
+		err = c.transactor.WithTx(ctx, func(ctx context.Context) error {
+			before := make([]*model.Company, 0, len(updates))
+			after := make([]*model.Company, 0, len(updates))
+			for _, n := range updates {
+				company := companies[n]
+				before = append(before, old[company.ID])
+				after = append(after, company)
+				// companies are checked above, so they must not be changed meanwhile
+				company.Version = old[company.ID].Version
+				if err := c.companyStorage.Update(ctx, company); err != nil {
+					if changedMeanwhile(err) {
+						return &batchItemError{n: n, err: err}
+					}
+					return errors.Wrapf(err, "updating company %d", company.ID)
+				}
+			}
+
+			updated, err := c.getBatch(ctx, after)
+			if err != nil {
+				return err
+			}
+
+			return c.recordBatch(ctx, model.EventCompanyUpdated, before, updated)
+		})
+		if !rejectBatchItem(err, errs) {
+			return errs, err
+		}
+	}
+}
+
+func (c Controller) BatchDeleteCompanies(ctx context.Context, companies []*model.Company, atomic bool) (errs []error, err error) {
+	ctx, span := tracing.Start(ctx, "Controller.BatchDeleteCompanies")
+	defer func() { tracing.End(span, err) }()
+
+	errs = c.checkBatchIDs(companies)
+
+	old, err := c.getOldBatch(ctx, companies, errs)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		if len(acceptBatch(companies, errs, atomic)) == 0 {
+			return errs, nil
+		}
+
+		err = c.transactor.WithTx(ctx, func(ctx context.Context) error {
+			before := make([]*model.Company, 0, len(companies))
+			for n, company := range companies {
+				if errs[n] != nil {
+					continue
+				}
+				before = append(before, old[company.ID])
+				if err := c.companyStorage.DeleteByID(ctx, company.ID, old[company.ID].Version); err != nil {
+					if changedMeanwhile(err) {
+						return &batchItemError{n: n, err: err}
+					}
+					return errors.Wrapf(err, "deleting company %d", company.ID)
+				}
+			}
+
+			return c.recordBatch(ctx, model.EventCompanyDeleted, before, nil)
+		})
+		if !rejectBatchItem(err, errs) {
+			return errs, err
+		}
+	}
+}
+
+// batchItemError tells which company failed batch transaction.
+type batchItemError struct {
+	n   int
+	err error
+}
+
+func (e *batchItemError) Error() string { return e.err.Error() }
+func (e *batchItemError) Unwrap() error { return e.err }
+
+// changedMeanwhile tells whether storage rejected company changed since companies were checked,
+// e.g. its code was taken or version changed. Such errors are reported for the company only.
+func changedMeanwhile(err error) bool {
+	return errors.Is(err, ierr.CompanyExists) || errors.Is(err, ierr.VersionMismatch) || errors.Is(err, ierr.CompanyNotFound)
+}
+
+// rejectBatchItem records error of the company which failed batch transaction, so the transaction
+// is retried without it. It returns false if err isn't caused by a single company.
+func rejectBatchItem(err error, errs []error) bool {
+	var itemErr *batchItemError
+	if !errors.As(err, &itemErr) {
+		return false
+	}
+	errs[itemErr.n] = itemErr.err
+	return true
+}
+
+// checkBatchIDs validates ids and versions of changed companies.
+func (c Controller) checkBatchIDs(companies []*model.Company) []error {
+	errs := make([]error, len(companies))
+	seen := make(map[int64]bool, len(companies))
+	for n, company := range companies {
+		switch {
+		case company == nil:
+			errs[n] = ierr.WrongRequest
+		case company.ID <= 0:
+			errs[n] = errors.Wrap(ierr.InvalidParam, "id")
+		case seen[company.ID]:
+			errs[n] = errors.Wrap(ierr.InvalidParam, "duplicate id")
+		case company.Version == 0 && c.config.API.RequireIfMatch:
+			errs[n] = errors.Wrap(ierr.VersionRequired, "version")
+		default:
+			seen[company.ID] = true
+		}
+	}
+	return errs
+}
+
+// getOldBatch returns companies by id, missing and changed ones are rejected.
+func (c Controller) getOldBatch(ctx context.Context, companies []*model.Company, errs []error) (map[int64]*model.Company, error) {
+	ids := make([]int64, 0, len(companies))
+	for n, company := range companies {
+		if errs[n] == nil {
+			ids = append(ids, company.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	list, err := c.companyStorage.GetListByFilter(ctx, dataprovider.NewCompanyFilter().ByIDs(ids...))
+	if err != nil {
+		return nil, err
+	}
+	old := make(map[int64]*model.Company, len(list))
+	for _, company := range list {
+		old[company.ID] = company
+	}
+
+	for n, company := range companies {
+		if errs[n] != nil {
+			continue
+		}
+		before, ok := old[company.ID]
+		switch {
+		case !ok:
+			errs[n] = ierr.CompanyNotFound
+		case company.Version > 0 && company.Version != before.Version:
+			errs[n] = ierr.VersionMismatch
+		}
+	}
+
+	return old, nil
+}
+
+// checkDuplicates rejects companies which codes, given with indexes of companies, are taken
+// by other companies. changed are companies being updated, nil for created ones.
+func (c Controller) checkDuplicates(ctx context.Context, byCode map[string]int, changed []*model.Company, errs []error) error {
+	if len(byCode) == 0 {
+		return nil
+	}
+
+	codes := make([]string, 0, len(byCode))
+	for code := range byCode {
+		codes = append(codes, code)
+	}
+
+	duplicates, err := c.companyStorage.GetListByFilter(ctx, dataprovider.NewCompanyFilter().ByCodes(codes...))
+	if err != nil {
+		return err
+	}
+	for _, duplicate := range duplicates {
+		n, ok := byCode[duplicate.Code]
+		if !ok || (changed != nil && changed[n].ID == duplicate.ID) {
+			continue
+		}
+		errs[n] = ierr.CompanyExists
+	}
+
+	return nil
+}
+
+// getBatch returns changed companies in order of given ones.
+func (c Controller) getBatch(ctx context.Context, companies []*model.Company) ([]*model.Company, error) {
+	ids := make([]int64, 0, len(companies))
+	for _, company := range companies {
+		ids = append(ids, company.ID)
+	}
+
+	list, err := c.companyStorage.GetListByFilter(ctx, dataprovider.NewCompanyFilter().ByIDs(ids...))
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int64]*model.Company, len(list))
+	for _, company := range list {
+		byID[company.ID] = company
+	}
+
+	result := make([]*model.Company, 0, len(companies))
+	for _, company := range companies {
+		changed, ok := byID[company.ID]
+		if !ok {
+			return nil, errors.Wrapf(ierr.CompanyNotFound, "getting changed company %d", company.ID)
+		}
+		result = append(result, changed)
+	}
+
+	return result, nil
+}
+
+// recordBatch stores a single event of the batch in outbox and revisions of every company, it has
+// to be called in the transaction changing companies. before and after are aligned by index, before
+// is nil for created companies, after is nil for deleted ones.
+func (c Controller) recordBatch(ctx context.Context, eventType string, before, after []*model.Company) error {
+	count := len(after)
+	if after == nil {
+		count = len(before)
+	}
+
+	batch := model.NewCompanyBatchEvent(eventType, actor(ctx))
+	revisions := make([]*model.CompanyRevision, 0, count)
+	for n := 0; n < count; n++ {
+		var b, a *model.Company
+		if before != nil {
+			b = before[n]
+		}
+		if after != nil {
+			a = after[n]
+		}
+
+		event := newEvent(ctx, eventType, b, a)
+		batch.Events = append(batch.Events, event)
+
+		revision, err := newRevision(ctx, event, b, a)
+		if err != nil {
+			return err
+		}
+		revisions = append(revisions, revision)
+	}
+
+	if err := c.notify(ctx, model.EventCompanyBatch, batch); err != nil {
+		return err
+	}
+
+	return c.revisionsStorage.InsertBatch(ctx, revisions)
+}
+
+// anyRejected tells whether any of companies, given by indexes, has an error.
+func anyRejected(indexes map[string]int, errs []error) bool {
+	for _, n := range indexes {
+		if errs[n] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptBatch returns companies having no errors. If atomic and any company is rejected,
+// none is accepted and the rest are failed with ierr.BatchAborted.
+func acceptBatch(companies []*model.Company, errs []error, atomic bool) []*model.Company {
+	accepted := make([]*model.Company, 0, len(companies))
+	for n, company := range companies {
+		if errs[n] == nil {
+			accepted = append(accepted, company)
+		}
+	}
+	if !atomic || len(accepted) == len(companies) {
+		return accepted
+	}
+
+	for n := range errs {
+		if errs[n] == nil {
+			errs[n] = ierr.BatchAborted
+		}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"context"
+	"github.com/IakimenkoD/xm-companies-service/internal/config"
+	ierr "github.com/IakimenkoD/xm-companies-service/internal/errors"
+	"github.com/IakimenkoD/xm-companies-service/internal/model"
+	"github.com/IakimenkoD/xm-companies-service/internal/repository/dataprovider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestBatchUpdateCompanies_ChangedMeanwhile(t *testing.T) {
+	tt := []struct {
+		name            string
+		atomic          bool
+		expected        []error
+		expectedUpdates uint64
+	}{
+		{
+			name:     "non-atomic batch reports failed companies",
+			expected: []error{nil, ierr.VersionMismatch, ierr.CompanyExists},
+			// the transaction is retried without the failed company: 1 and 2, 1 and 3, then 1 alone
+			expectedUpdates: 5,
+		},
+		{
+			name:            "atomic batch is aborted",
+			atomic:          true,
+			expected:        []error{ierr.BatchAborted, ierr.VersionMismatch, ierr.BatchAborted},
+			expectedUpdates: 2,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			stored := map[int64]*model.Company{
+				1: {ID: 1, Name: "One", Code: "1111", Country: "CY", Website: "one.cy", Version: 1},
+				2: {ID: 2, Name: "Two", Code: "2222", Country: "CY", Website: "two.cy", Version: 1},
+				3: {ID: 3, Name: "Three", Code: "3333", Country: "CY", Website: "three.cy", Version: 1},
+			}
+			storage := dataprovider.NewCompaniesStorageMock(t).
+				GetListByFilterMock.Set(func(_ context.Context, filter *dataprovider.CompanyFilter) ([]*model.Company, error) {
+				var list []*model.Company
+				for _, id := range filter.IDs {
+					if company, ok := stored[id]; ok {
+						list = append(list, company)
+					}
+				}
+				return list, nil
+			}).
+				UpdateMock.Set(func(_ context.Context, company *model.Company) error {
+				// company 2 is changed and code 4444 is taken after the batch is checked
+				switch company.ID {
+				case 2:
+					return ierr.VersionMismatch
+				case 3:
+					return ierr.CompanyExists
+				}
+				return nil
+			})
+			c := Controller{
+				config:           &config.Config{},
+				companyStorage:   storage,
+				outboxStorage:    dataprovider.NewOutboxStorageMock(t).InsertMock.Return(nil),
+				revisionsStorage: dataprovider.NewRevisionsStorageMock(t).InsertBatchMock.Return(nil),
+				transactor: dataprovider.NewTransactorMock(t).WithTxMock.Set(func(ctx context.Context, fn func(ctx context.Context) error) error {
+					return fn(ctx)
+				}),
+			}
+
+			errs, err := c.BatchUpdateCompanies(context.Background(), []*model.Company{
+				{ID: 1, Name: "One!", Code: "1111", Country: "CY", Website: "one.cy"},
+				{ID: 2, Name: "Two!", Code: "2222", Country: "CY", Website: "two.cy"},
+				{ID: 3, Name: "Three!", Code: "4444", Country: "CY", Website: "three.cy"},
+			}, tc.atomic)
+			require.NoError(t, err)
+			require.Len(t, errs, len(tc.expected))
+			for n, expected := range tc.expected {
+				if expected == nil {
+					assert.NoError(t, errs[n], "company #%d", n)
+				} else {
+					assert.ErrorIs(t, errs[n], expected, "company #%d", n)
+				}
+			}
+			assert.EqualValues(t, tc.expectedUpdates, storage.UpdateAfterCounter())
+		})
+	}
+}
+
+func TestBatchCreateCompanies_CodeTakenMeanwhile(t *testing.T) {
+	tt := []struct {
+		name            string
+		atomic          bool
+		expected        []error
+		expectedInserts uint64
+	}{
+		{
+			name:            "non-atomic batch reports failed companies",
+			expected:        []error{nil, ierr.CompanyExists},
+			expectedInserts: 2,
+		},
+		{
+			name:            "atomic batch is aborted",
+			atomic:          true,
+			expected:        []error{ierr.BatchAborted, ierr.CompanyExists},
+			expectedInserts: 1,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			var stored []*model.Company
+			storage := dataprovider.NewCompaniesStorageMock(t).
+				GetListByFilterMock.Set(func(_ context.Context, filter *dataprovider.CompanyFilter) ([]*model.Company, error) {
+				var list []*model.Company
+				for _, company := range stored {
+					for _, id := range filter.IDs {
+						if company.ID == id {
+							list = append(list, company)
+						}
+					}
+					for _, code := range filter.Codes {
+						if company.Code == code {
+							list = append(list, company)
+						}
+					}
+				}
+				return list, nil
+			}).
+				InsertBatchMock.Set(func(_ context.Context, companies []*model.Company) error {
+				if len(stored) == 0 {
+					// code 2222 is taken by a concurrent request after the batch is checked
+					stored = append(stored, &model.Company{ID: 10, Code: "2222"})
+					return ierr.CompanyExists
+				}
+				for n, company := range companies {
+					company.ID = int64(n + 1)
+					stored = append(stored, company)
+				}
+				return nil
+			})
+			c := Controller{
+				config:           &config.Config{},
+				companyStorage:   storage,
+				outboxStorage:    dataprovider.NewOutboxStorageMock(t).InsertMock.Return(nil),
+				revisionsStorage: dataprovider.NewRevisionsStorageMock(t).InsertBatchMock.Return(nil),
+				transactor: dataprovider.NewTransactorMock(t).WithTxMock.Set(func(ctx context.Context, fn func(ctx context.Context) error) error {
+					return fn(ctx)
+				}),
+			}
+
+			batch := []*model.Company{
+				{Name: "One", Code: "1111", Country: "CY", Website: "one.cy"},
+				{Name: "Two", Code: "2222", Country: "CY", Website: "two.cy"},
+			}
+			errs, err := c.BatchCreateCompanies(context.Background(), batch, tc.atomic)
+			require.NoError(t, err)
+			require.Len(t, errs, len(tc.expected))
+			for n, expected := range tc.expected {
+				if expected == nil {
+					assert.NoError(t, errs[n], "company #%d", n)
+				} else {
+					assert.ErrorIs(t, errs[n], expected, "company #%d", n)
+				}
+			}
+			assert.EqualValues(t, tc.expectedInserts, storage.InsertBatchAfterCounter())
+			if !tc.atomic {
+				assert.EqualValues(t, 1, batch[0].ID)
+			}
+		})
+	}
+}
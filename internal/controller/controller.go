@@ -2,11 +2,14 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
 	"github.com/IakimenkoD/xm-companies-service/internal/config"
 	ierr "github.com/IakimenkoD/xm-companies-service/internal/errors"
 	"github.com/IakimenkoD/xm-companies-service/internal/model"
 	"github.com/IakimenkoD/xm-companies-service/internal/repository/dataprovider"
-	"github.com/IakimenkoD/xm-companies-service/internal/service"
+	"github.com/IakimenkoD/xm-companies-service/internal/tracing"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 //go:generate minimock -i CompaniesService -g -o controller_mock.go
@@ -14,57 +17,114 @@ import (
 // CompaniesService is a main controller for business logic.
 type CompaniesService interface {
 	CreateCompany(ctx context.Context, company *model.Company) (int64, error)
-	GetCompanies(ctx context.Context, filter *dataprovider.CompanyFilter) ([]*model.Company, error)
+	GetCompanies(ctx context.Context, filter *dataprovider.CompanyFilter) ([]*model.Company, *dataprovider.Cursor, error)
+	// UpdateCompany changes company if its version equals company.Version,
+	// ierr.VersionMismatch is returned otherwise, 0 version matches any.
+	// company.Version is set to the version after the change.
 	UpdateCompany(ctx context.Context, company *model.Company) error
-	PatchCompany(ctx context.Context, company *model.Company) (*model.Company, error)
-	DeleteCompany(ctx context.Context, id int64) error
+	// PatchCompany applies patch to the company of given version, 0 version matches any.
+	PatchCompany(ctx context.Context, id, version int64, patch model.Patch) (*model.Company, error)
+	// DeleteCompany deletes company of given version, 0 version matches any.
+	DeleteCompany(ctx context.Context, id, version int64) error
+	// RestoreCompany brings deleted company back unless its code is taken by another company.
+	RestoreCompany(ctx context.Context, id int64) error
+	// GetCompanyHistory returns revisions page of the company, the latest first, and cursor to the next page.
+	GetCompanyHistory(ctx context.Context, id int64, filter *dataprovider.RevisionFilter) ([]*model.CompanyRevision, *dataprovider.Cursor, error)
+
+	// Batch operations validate all companies first and apply accepted ones in a single transaction
+	// publishing one event. Errors of rejected companies are returned by their indexes, if atomic
+	// and any company is rejected, nothing is changed and the rest fail with ierr.BatchAborted.
+
+	// BatchCreateCompanies creates companies and sets their ids.
+	BatchCreateCompanies(ctx context.Context, companies []*model.Company, atomic bool) ([]error, error)
+	// ValidateCompanies checks companies as BatchCreateCompanies does without creating them.
+	ValidateCompanies(ctx context.Context, companies []*model.Company) ([]error, error)
+	// BatchUpdateCompanies changes companies of given versions, 0 version matches any.
+	BatchUpdateCompanies(ctx context.Context, companies []*model.Company, atomic bool) ([]error, error)
+	// BatchDeleteCompanies deletes companies of given versions, only ID and Version are used.
+	BatchDeleteCompanies(ctx context.Context, companies []*model.Company, atomic bool) ([]error, error)
 }
 
 type Controller struct {
-	config         *config.Config
-	companyStorage dataprovider.CompaniesStorage
-	mq             service.MessageQueue
+	config           *config.Config
+	companyStorage   dataprovider.CompaniesStorage
+	outboxStorage    dataprovider.OutboxStorage
+	revisionsStorage dataprovider.RevisionsStorage
+	transactor       dataprovider.Transactor
 }
 
 func NewCompaniesService(cfg *config.Config,
 	companyStorage dataprovider.CompaniesStorage,
-	mq service.MessageQueue) CompaniesService {
+	outboxStorage dataprovider.OutboxStorage,
+	revisionsStorage dataprovider.RevisionsStorage,
+	transactor dataprovider.Transactor) CompaniesService {
 	return &Controller{
-		config:         cfg,
-		companyStorage: companyStorage,
-		mq:             mq,
+		config:           cfg,
+		companyStorage:   companyStorage,
+		outboxStorage:    outboxStorage,
+		revisionsStorage: revisionsStorage,
+		transactor:       transactor,
 	}
 }
 
 func (c Controller) CreateCompany(ctx context.Context, company *model.Company) (id int64, err error) {
+	ctx, span := tracing.Start(ctx, "Controller.CreateCompany")
+	defer func() { tracing.End(span, err) }()
+
 	if company == nil {
 		return id, ierr.WrongRequest
 	}
-	f := dataprovider.NewCompanyFilter().ByCodes(company.Code)
-	duplicates, err := c.companyStorage.GetListByFilter(ctx, f)
-	if err != nil {
-		return id, err
-	}
-	if len(duplicates) > 0 {
-		return id, ierr.CompanyExists
+
+	err = c.transactor.WithTx(ctx, func(ctx context.Context) error {
+		// storage returns CompanyExists if the code is taken, checking it beforehand would race
+		// with companies created meanwhile
+		id, err = c.companyStorage.Insert(ctx, company)
+		if err != nil {
+			return err
+		}
+		company.ID = id
+
+		created, err := c.companyStorage.GetByFilter(ctx, dataprovider.NewCompanyFilter().ByIDs(id))
+		if err != nil {
+			return err
+		}
+
+		return c.recordChange(ctx, model.EventCompanyCreated, nil, created)
+	})
+	return id, err
+}
+
+// GetCompanies returns companies page and cursor to the next one, cursor is nil for the last page.
+func (c Controller) GetCompanies(ctx context.Context, filter *dataprovider.CompanyFilter) (companies []*model.Company, next *dataprovider.Cursor, err error) {
+	ctx, span := tracing.Start(ctx, "Controller.GetCompanies")
+	defer func() { tracing.End(span, err) }()
+
+	limit := filter.Limit
+	if limit == 0 {
+		companies, err = c.companyStorage.GetListByFilter(ctx, filter)
+		return companies, nil, err
 	}
 
-	id, err = c.companyStorage.Insert(ctx, company)
+	// one extra row tells whether the next page exists, the caller's filter is kept intact
+	page := *filter
+	page.WithLimit(limit + 1)
+
+	companies, err = c.companyStorage.GetListByFilter(ctx, &page)
 	if err != nil {
-		return id, err
+		return nil, nil, err
 	}
-	company.ID = id
-	if err = c.mq.NotifyCompanyUpdated(company); err != nil {
-		return id, err
+	if uint64(len(companies)) <= limit {
+		return companies, nil, nil
 	}
-	return id, nil
-}
 
-func (c Controller) GetCompanies(ctx context.Context, filter *dataprovider.CompanyFilter) ([]*model.Company, error) {
-	return c.companyStorage.GetListByFilter(ctx, filter)
+	companies = companies[:limit]
+	return companies, dataprovider.NewCursor(filter.Sort, companies[limit-1]), nil
 }
 
-func (c Controller) UpdateCompany(ctx context.Context, company *model.Company) error {
+func (c Controller) UpdateCompany(ctx context.Context, company *model.Company) (err error) {
+	ctx, span := tracing.Start(ctx, "Controller.UpdateCompany")
+	defer func() { tracing.End(span, err) }()
+
 	if company == nil {
 		return ierr.WrongRequest
 	}
@@ -79,30 +139,35 @@ func (c Controller) UpdateCompany(ctx context.Context, company *model.Company) e
 		return ierr.CompanyNotFound
 	}
 
+	if company.Version > 0 && company.Version != old.Version {
+		return ierr.VersionMismatch
+	}
+
 	if old.Equal(company) {
+		company.Version = old.Version
 		return nil
 	}
 
-	if err = c.companyStorage.Update(ctx, company); err != nil {
-		return err
-	}
+	return c.transactor.WithTx(ctx, func(ctx context.Context) error {
+		if err = c.companyStorage.Update(ctx, company); err != nil {
+			return err
+		}
 
-	updated, err := c.companyStorage.GetByFilter(ctx, f)
-	if err != nil {
-		return err
-	}
+		updated, err := c.companyStorage.GetByFilter(ctx, f)
+		if err != nil {
+			return err
+		}
+		company.Version = updated.Version
 
-	if err = c.mq.NotifyCompanyUpdated(updated); err != nil {
-		return err
-	}
-	return nil
+		return c.recordChange(ctx, model.EventCompanyUpdated, old, updated)
+	})
 }
 
-func (c Controller) PatchCompany(ctx context.Context, company *model.Company) (*model.Company, error) {
-	if company == nil {
-		return nil, ierr.WrongRequest
-	}
-	f := dataprovider.NewCompanyFilter().ByIDs(company.ID)
+func (c Controller) PatchCompany(ctx context.Context, id, version int64, patch model.Patch) (updated *model.Company, err error) {
+	ctx, span := tracing.Start(ctx, "Controller.PatchCompany")
+	defer func() { tracing.End(span, err) }()
+
+	f := dataprovider.NewCompanyFilter().ByIDs(id)
 	old, err := c.companyStorage.GetByFilter(ctx, f)
 	if err != nil {
 		return nil, err
@@ -110,22 +175,42 @@ func (c Controller) PatchCompany(ctx context.Context, company *model.Company) (*
 	if old == nil {
 		return nil, ierr.CompanyNotFound
 	}
-
-	if err = c.companyStorage.Update(ctx, company); err != nil {
-		return nil, err
+	if version > 0 && version != old.Version {
+		return nil, ierr.VersionMismatch
 	}
 
-	updated, err := c.companyStorage.GetByFilter(ctx, f)
+	patched, err := old.ApplyPatch(patch)
 	if err != nil {
 		return nil, err
 	}
-	if err = c.mq.NotifyCompanyUpdated(updated); err != nil {
+	if old.Equal(patched) {
+		return old, nil
+	}
+
+	err = c.transactor.WithTx(ctx, func(ctx context.Context) error {
+		// patch is applied to the company read above, so it must not be changed meanwhile,
+		// storage returns CompanyExists if the patched code is taken
+		if err = c.companyStorage.Update(ctx, patched); err != nil {
+			return err
+		}
+
+		updated, err = c.companyStorage.GetByFilter(ctx, f)
+		if err != nil {
+			return err
+		}
+
+		return c.recordChange(ctx, model.EventCompanyUpdated, old, updated)
+	})
+	if err != nil {
 		return nil, err
 	}
 	return updated, nil
 }
 
-func (c Controller) DeleteCompany(ctx context.Context, id int64) error {
+func (c Controller) DeleteCompany(ctx context.Context, id, version int64) (err error) {
+	ctx, span := tracing.Start(ctx, "Controller.DeleteCompany")
+	defer func() { tracing.End(span, err) }()
+
 	filter := dataprovider.NewCompanyFilter().ByIDs(id)
 	company, err := c.companyStorage.GetByFilter(ctx, filter)
 	if err != nil {
@@ -134,5 +219,169 @@ func (c Controller) DeleteCompany(ctx context.Context, id int64) error {
 	if company == nil {
 		return ierr.CompanyNotFound
 	}
-	return c.companyStorage.DeleteByID(ctx, id)
+	if version > 0 && version != company.Version {
+		return ierr.VersionMismatch
+	}
+
+	return c.transactor.WithTx(ctx, func(ctx context.Context) error {
+		if err = c.companyStorage.DeleteByID(ctx, id, version); err != nil {
+			return err
+		}
+
+		return c.recordChange(ctx, model.EventCompanyDeleted, company, nil)
+	})
+}
+
+func (c Controller) RestoreCompany(ctx context.Context, id int64) (err error) {
+	ctx, span := tracing.Start(ctx, "Controller.RestoreCompany")
+	defer func() { tracing.End(span, err) }()
+
+	filter := dataprovider.NewCompanyFilter().ByIDs(id).WithDeleted(true)
+	company, err := c.companyStorage.GetByFilter(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if company == nil {
+		return ierr.CompanyNotFound
+	}
+	if company.DeletedAt == nil {
+		return nil
+	}
+
+	return c.transactor.WithTx(ctx, func(ctx context.Context) error {
+		// storage returns CompanyExists if another company took the code, checking it beforehand
+		// would race with companies created meanwhile
+		if err = c.companyStorage.RestoreByID(ctx, id); err != nil {
+			return err
+		}
+
+		restored, err := c.companyStorage.GetByFilter(ctx, dataprovider.NewCompanyFilter().ByIDs(id))
+		if err != nil {
+			return err
+		}
+
+		return c.recordChange(ctx, model.EventCompanyRestored, company, restored)
+	})
+}
+
+func (c Controller) GetCompanyHistory(ctx context.Context, id int64, filter *dataprovider.RevisionFilter) (revisions []*model.CompanyRevision, next *dataprovider.Cursor, err error) {
+	ctx, span := tracing.Start(ctx, "Controller.GetCompanyHistory")
+	defer func() { tracing.End(span, err) }()
+
+	// the caller's filter is kept intact
+	page := *filter
+	page.ByCompanyIDs(id)
+
+	limit := filter.Limit
+	if limit > 0 {
+		// one extra row tells whether the next page exists
+		page.WithLimit(limit + 1)
+	}
+
+	revisions, err = c.revisionsStorage.GetListByFilter(ctx, &page)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(revisions) == 0 && filter.BeforeID == 0 {
+		// history of purged company is kept, so the company is checked only if there is no history
+		company, err := c.companyStorage.GetByFilter(ctx, dataprovider.NewCompanyFilter().ByIDs(id).WithDeleted(true))
+		if err != nil {
+			return nil, nil, err
+		}
+		if company == nil {
+			return nil, nil, ierr.CompanyNotFound
+		}
+	}
+
+	if limit == 0 || uint64(len(revisions)) <= limit {
+		return revisions, nil, nil
+	}
+
+	revisions = revisions[:limit]
+	return revisions, dataprovider.NewRevisionCursor(revisions[limit-1]), nil
+}
+
+// recordChange stores event of the change in outbox and its revision in audit log, it has to be called
+// in the transaction changing the company. before is nil for created company, after is nil for deleted one.
+func (c Controller) recordChange(ctx context.Context, eventType string, before, after *model.Company) error {
+	event := newEvent(ctx, eventType, before, after)
+	if err := c.notify(ctx, event.Type, event); err != nil {
+		return err
+	}
+
+	revision, err := newRevision(ctx, event, before, after)
+	if err != nil {
+		return err
+	}
+
+	return c.revisionsStorage.Insert(ctx, revision)
+}
+
+// notify stores event in outbox, it has to be called in the transaction changing the company.
+func (c Controller) notify(ctx context.Context, eventType string, event interface{}) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "marshalling company event")
+	}
+
+	// the event is published later, so the trace of the change is kept with it
+	carrier := propagation.MapCarrier{}
+	tracing.Inject(ctx, carrier)
+	headers, err := json.Marshal(carrier)
+	if err != nil {
+		return errors.Wrap(err, "marshalling trace context")
+	}
+
+	return c.outboxStorage.Insert(ctx, &model.OutboxMessage{
+		EventType: eventType,
+		Payload:   payload,
+		Headers:   headers,
+	})
+}
+
+func newEvent(ctx context.Context, eventType string, before, after *model.Company) *model.CompanyEvent {
+	company := after
+	if company == nil {
+		company = before
+	}
+
+	event := model.NewCompanyEvent(eventType, actor(ctx), company)
+	if eventType == model.EventCompanyUpdated {
+		event.Changes = after.Diff(before)
+	}
+
+	return event
+}
+
+func newRevision(ctx context.Context, event *model.CompanyEvent, before, after *model.Company) (*model.CompanyRevision, error) {
+	revision := &model.CompanyRevision{
+		CompanyID: event.Company.ID,
+		Action:    event.Type,
+		Actor:     event.Actor,
+		ClientIP:  model.ClientIPFromContext(ctx),
+	}
+
+	var err error
+	if before != nil {
+		if revision.Before, err = json.Marshal(before); err != nil {
+			return nil, errors.Wrap(err, "marshalling company revision")
+		}
+	}
+	if after != nil {
+		if revision.After, err = json.Marshal(after); err != nil {
+			return nil, errors.Wrap(err, "marshalling company revision")
+		}
+	}
+
+	return revision, nil
+}
+
+// actor returns name of the user making the request.
+func actor(ctx context.Context) string {
+	if claims := model.ClaimsFromContext(ctx); claims != nil {
+		return claims.Username
+	}
+
+	return ""
 }
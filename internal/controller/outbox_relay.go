@@ -0,0 +1,156 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/IakimenkoD/xm-companies-service/internal/config"
+	"github.com/IakimenkoD/xm-companies-service/internal/model"
+	"github.com/IakimenkoD/xm-companies-service/internal/repository/dataprovider"
+	"github.com/IakimenkoD/xm-companies-service/internal/service"
+	"github.com/IakimenkoD/xm-companies-service/internal/tracing"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"time"
+)
+
+// OutboxRelay publishes events stored in outbox to the message queue.
+type OutboxRelay struct {
+	config        *config.Config
+	outboxStorage dataprovider.OutboxStorage
+	transactor    dataprovider.Transactor
+	mq            service.MessageQueue
+	log           *zap.Logger
+}
+
+func NewOutboxRelay(cfg *config.Config,
+	outboxStorage dataprovider.OutboxStorage,
+	transactor dataprovider.Transactor,
+	mq service.MessageQueue,
+	log *zap.Logger) *OutboxRelay {
+	return &OutboxRelay{
+		config:        cfg,
+		outboxStorage: outboxStorage,
+		transactor:    transactor,
+		mq:            mq,
+		log:           log,
+	}
+}
+
+// Run drains outbox until ctx is cancelled.
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.config.Outbox.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for {
+				n, err := r.relayBatch(ctx)
+				if err != nil {
+					r.log.Error("relaying outbox messages", zap.Error(err))
+					break
+				}
+				if uint64(n) < r.config.Outbox.BatchSize {
+					break
+				}
+			}
+		}
+	}
+}
+
+// relayBatch claims a batch of pending messages and publishes them in order outside of any transaction,
+// as publishing waits for the broker. It stops on the first failure, the rest of the batch is released
+// to be published on the next tick, so messages may be delivered out of order after failures.
+func (r *OutboxRelay) relayBatch(ctx context.Context) (published int, err error) {
+	messages, err := r.outboxStorage.ClaimPending(ctx, r.config.Outbox.BatchSize, time.Now().Add(r.config.Outbox.Lease))
+	if err != nil {
+		return 0, err
+	}
+
+	var failed *model.OutboxMessage
+	var pubErr error
+	for _, msg := range messages {
+		if pubErr = r.publish(ctx, msg); pubErr != nil {
+			failed = msg
+			break
+		}
+		published++
+	}
+
+	err = r.transactor.WithTx(ctx, func(ctx context.Context) error {
+		for _, msg := range messages[:published] {
+			if err := r.outboxStorage.DeleteByID(ctx, msg.ID); err != nil {
+				return err
+			}
+		}
+		if failed == nil {
+			return nil
+		}
+
+		r.log.Warn("publishing outbox message",
+			zap.Int64("id", failed.ID),
+			zap.Int("attempts", failed.Attempts+1),
+			zap.Error(pubErr))
+		if err := r.outboxStorage.MarkFailed(ctx, failed.ID, pubErr.Error(), time.Now().Add(r.backoff(failed.Attempts))); err != nil {
+			return err
+		}
+
+		rest := make([]int64, 0, len(messages)-published-1)
+		for _, msg := range messages[published+1:] {
+			rest = append(rest, msg.ID)
+		}
+		return r.outboxStorage.Release(ctx, rest...)
+	})
+	return published, err
+}
+
+// publish continues trace of the change which stored the message.
+func (r *OutboxRelay) publish(ctx context.Context, msg *model.OutboxMessage) (err error) {
+	if len(msg.Headers) > 0 {
+		carrier := propagation.MapCarrier{}
+		if err = json.Unmarshal(msg.Headers, &carrier); err != nil {
+			return errors.Wrap(err, "unmarshalling outbox message headers")
+		}
+		ctx = tracing.Extract(ctx, carrier)
+	}
+	ctx, span := tracing.Start(ctx, "OutboxRelay.publish", trace.WithAttributes(
+		attribute.Int64("outbox.id", msg.ID),
+		attribute.Int("outbox.attempts", msg.Attempts),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	switch msg.EventType {
+	case model.EventCompanyCreated, model.EventCompanyUpdated, model.EventCompanyDeleted, model.EventCompanyRestored:
+		event := &model.CompanyEvent{}
+		if err := json.Unmarshal(msg.Payload, event); err != nil {
+			return errors.Wrap(err, "unmarshalling company event")
+		}
+		return r.mq.NotifyCompanyChanged(ctx, event)
+	case model.EventCompanyBatch:
+		batch := &model.CompanyBatchEvent{}
+		if err := json.Unmarshal(msg.Payload, batch); err != nil {
+			return errors.Wrap(err, "unmarshalling company batch event")
+		}
+		return r.mq.NotifyCompaniesChanged(ctx, batch)
+	default:
+		return errors.Errorf("unknown outbox event type %q", msg.EventType)
+	}
+}
+
+// backoff doubles delay for every failed attempt up to Outbox.MaxBackoff.
+func (r *OutboxRelay) backoff(attempts int) time.Duration {
+	delay := r.config.Outbox.MinBackoff
+	for i := 0; i < attempts && delay < r.config.Outbox.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > r.config.Outbox.MaxBackoff {
+		delay = r.config.Outbox.MaxBackoff
+	}
+
+	return delay
+}
@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/IakimenkoD/xm-companies-service/internal/config"
+	"github.com/IakimenkoD/xm-companies-service/internal/model"
+	"github.com/IakimenkoD/xm-companies-service/internal/repository/dataprovider"
+	"github.com/IakimenkoD/xm-companies-service/internal/service"
+	"github.com/IakimenkoD/xm-companies-service/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func TestOutboxRelay_ContinuesTraceOfChange(t *testing.T) {
+	recorder := tracing.Record()
+
+	var stored *model.OutboxMessage
+	c := Controller{
+		outboxStorage: dataprovider.NewOutboxStorageMock(t).InsertMock.Set(func(_ context.Context, msg *model.OutboxMessage) error {
+			stored = msg
+			return nil
+		}),
+	}
+
+	ctx, change := tracing.Start(context.Background(), "Controller.UpdateCompany")
+	event := model.NewCompanyEvent(model.EventCompanyUpdated, "user", &model.Company{ID: 1, Country: "cy"})
+	require.NoError(t, c.notify(ctx, event.Type, event))
+	change.End()
+
+	require.NotNil(t, stored)
+	var headers map[string]string
+	require.NoError(t, json.Unmarshal(stored.Headers, &headers))
+	assert.Equal(t, "00-"+change.SpanContext().TraceID().String()+"-"+change.SpanContext().SpanID().String()+"-01",
+		headers["traceparent"], "trace context of the change is stored with the event")
+
+	var published trace.SpanContext
+	mq := service.NewMessageQueueMock(t).NotifyCompanyChangedMock.Set(func(ctx context.Context, _ *model.CompanyEvent) error {
+		published = trace.SpanContextFromContext(ctx)
+		return nil
+	})
+	relay := NewOutboxRelay(&config.Config{}, nil, nil, mq, zap.NewNop())
+
+	// the relay polls outbox out of any trace
+	require.NoError(t, relay.publish(context.Background(), stored))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	relaySpan := spans[1]
+	assert.Equal(t, "OutboxRelay.publish", relaySpan.Name())
+	assert.Equal(t, change.SpanContext().TraceID(), relaySpan.SpanContext().TraceID())
+	assert.Equal(t, change.SpanContext().SpanID(), relaySpan.Parent().SpanID())
+	assert.Equal(t, relaySpan.SpanContext(), published, "event is published in trace of the change")
+}
@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/IakimenkoD/xm-companies-service/internal/config"
+	ierr "github.com/IakimenkoD/xm-companies-service/internal/errors"
+	"github.com/IakimenkoD/xm-companies-service/internal/model"
+	"github.com/IakimenkoD/xm-companies-service/internal/repository/dataprovider"
+	"github.com/pkg/errors"
+	"time"
+)
+
+//go:generate minimock -i SessionsService -g -o sessions_mock.go
+
+// SessionsService manages rotating refresh tokens and revocation of access tokens.
+type SessionsService interface {
+	// CreateSession issues refresh token for the user.
+	CreateSession(ctx context.Context, user *model.User) (refreshToken string, err error)
+	// Refresh revokes given refresh token and issues a new one, presenting already revoked token
+	// revokes all sessions of the user as the token is considered stolen.
+	Refresh(ctx context.Context, refreshToken string) (user *model.User, newRefreshToken string, err error)
+	RevokeSession(ctx context.Context, refreshToken string) error
+
+	RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+type SessionController struct {
+	config        *config.Config
+	usersStorage  dataprovider.UsersStorage
+	tokensStorage dataprovider.TokensStorage
+	transactor    dataprovider.Transactor
+}
+
+func NewSessionsService(cfg *config.Config,
+	usersStorage dataprovider.UsersStorage,
+	tokensStorage dataprovider.TokensStorage,
+	transactor dataprovider.Transactor) SessionsService {
+	return &SessionController{
+		config:        cfg,
+		usersStorage:  usersStorage,
+		tokensStorage: tokensStorage,
+		transactor:    transactor,
+	}
+}
+
+func (c SessionController) CreateSession(ctx context.Context, user *model.User) (string, error) {
+	token, err := newRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = c.tokensStorage.InsertRefreshToken(ctx, &model.RefreshToken{
+		UserID:    user.ID,
+		TokenHash: hashRefreshToken(token),
+		ExpiresAt: time.Now().Add(c.config.API.RefreshTokenTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (c SessionController) Refresh(ctx context.Context, refreshToken string) (user *model.User, newToken string, err error) {
+	var reused bool
+	err = c.transactor.WithTx(ctx, func(ctx context.Context) error {
+		stored, err := c.tokensStorage.GetRefreshToken(ctx, hashRefreshToken(refreshToken))
+		if err != nil {
+			return err
+		}
+		if stored == nil || time.Now().After(stored.ExpiresAt) {
+			return ierr.InvalidCredentials
+		}
+
+		if stored.RevokedAt != nil {
+			reused = true
+			return c.tokensStorage.RevokeUserRefreshTokens(ctx, stored.UserID)
+		}
+
+		if err = c.tokensStorage.RevokeRefreshToken(ctx, stored.ID); err != nil {
+			return err
+		}
+
+		user, err = c.usersStorage.GetByFilter(ctx, dataprovider.NewUserFilter().ByIDs(stored.UserID))
+		if err != nil {
+			return err
+		}
+		if user == nil || user.Disabled {
+			return ierr.InvalidCredentials
+		}
+
+		newToken, err = c.CreateSession(ctx, user)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if reused {
+		return nil, "", ierr.InvalidCredentials
+	}
+
+	return user, newToken, nil
+}
+
+func (c SessionController) RevokeSession(ctx context.Context, refreshToken string) error {
+	return c.transactor.WithTx(ctx, func(ctx context.Context) error {
+		stored, err := c.tokensStorage.GetRefreshToken(ctx, hashRefreshToken(refreshToken))
+		if err != nil || stored == nil {
+			return err
+		}
+
+		return c.tokensStorage.RevokeRefreshToken(ctx, stored.ID)
+	})
+}
+
+func (c SessionController) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	return c.tokensStorage.RevokeAccessToken(ctx, jti, expiresAt)
+}
+
+func (c SessionController) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	return c.tokensStorage.IsAccessTokenRevoked(ctx, jti)
+}
+
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating refresh token")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
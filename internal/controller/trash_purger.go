@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"context"
+	"github.com/IakimenkoD/xm-companies-service/internal/config"
+	"github.com/IakimenkoD/xm-companies-service/internal/repository/dataprovider"
+	"go.uber.org/zap"
+	"time"
+)
+
+// TrashPurger removes companies deleted longer than Trash.Retention ago.
+type TrashPurger struct {
+	config         *config.Config
+	companyStorage dataprovider.CompaniesStorage
+	log            *zap.Logger
+}
+
+func NewTrashPurger(cfg *config.Config, companyStorage dataprovider.CompaniesStorage, log *zap.Logger) *TrashPurger {
+	return &TrashPurger{
+		config:         cfg,
+		companyStorage: companyStorage,
+		log:            log,
+	}
+}
+
+// Run purges trash every Trash.PurgeInterval until ctx is cancelled.
+func (p *TrashPurger) Run(ctx context.Context) {
+	if p.config.Trash.PurgeInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(p.config.Trash.PurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := p.companyStorage.PurgeDeleted(ctx, time.Now().Add(-p.config.Trash.Retention))
+			if err != nil {
+				p.log.Error("purging deleted companies", zap.Error(err))
+				continue
+			}
+			if purged > 0 {
+				p.log.Info("deleted companies purged", zap.Int64("count", purged))
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package controller
+
+import (
+	"context"
+	"github.com/IakimenkoD/xm-companies-service/internal/config"
+	ierr "github.com/IakimenkoD/xm-companies-service/internal/errors"
+	"github.com/IakimenkoD/xm-companies-service/internal/model"
+	"github.com/IakimenkoD/xm-companies-service/internal/repository/dataprovider"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const minPasswordLength = 8
+
+// dummyHash is compared against when user doesn't exist, so response time doesn't reveal registered usernames.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+//go:generate minimock -i UsersService -g -o users_mock.go
+
+// UsersService manages user accounts and their credentials.
+type UsersService interface {
+	SignIn(ctx context.Context, username, password string) (*model.User, error)
+	CreateUser(ctx context.Context, user *model.User, password string) (int64, error)
+	// EnsureUser creates user if there is no user with the same username.
+	EnsureUser(ctx context.Context, user *model.User, password string) error
+	// SetDisabled and ResetPassword revoke refresh tokens of the user.
+	SetDisabled(ctx context.Context, id int64, disabled bool) error
+	ResetPassword(ctx context.Context, id int64, password string) error
+}
+
+type UserController struct {
+	config        *config.Config
+	usersStorage  dataprovider.UsersStorage
+	tokensStorage dataprovider.TokensStorage
+	transactor    dataprovider.Transactor
+}
+
+func NewUsersService(cfg *config.Config,
+	usersStorage dataprovider.UsersStorage,
+	tokensStorage dataprovider.TokensStorage,
+	transactor dataprovider.Transactor) UsersService {
+	return &UserController{
+		config:        cfg,
+		usersStorage:  usersStorage,
+		tokensStorage: tokensStorage,
+		transactor:    transactor,
+	}
+}
+
+func (c UserController) SignIn(ctx context.Context, username, password string) (*model.User, error) {
+	user, err := c.usersStorage.GetByFilter(ctx, dataprovider.NewUserFilter().ByUsernames(username))
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ierr.InvalidCredentials
+	}
+
+	if err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return nil, ierr.InvalidCredentials
+	}
+
+	if user.Disabled {
+		return nil, ierr.InvalidCredentials
+	}
+
+	return user, nil
+}
+
+func (c UserController) CreateUser(ctx context.Context, user *model.User, password string) (id int64, err error) {
+	if user == nil {
+		return id, ierr.WrongRequest
+	}
+	if user.Role == "" {
+		user.Role = model.RoleUser
+	}
+	if err = user.CheckFields(); err != nil {
+		return id, err
+	}
+
+	existing, err := c.usersStorage.GetByFilter(ctx, dataprovider.NewUserFilter().ByUsernames(user.Username))
+	if err != nil {
+		return id, err
+	}
+	if existing != nil {
+		return id, ierr.UserExists
+	}
+
+	if user.PasswordHash, err = hashPassword(password); err != nil {
+		return id, err
+	}
+
+	if id, err = c.usersStorage.Insert(ctx, user); err != nil {
+		return id, err
+	}
+	user.ID = id
+
+	return id, nil
+}
+
+func (c UserController) EnsureUser(ctx context.Context, user *model.User, password string) error {
+	if _, err := c.CreateUser(ctx, user, password); err != nil && !errors.Is(err, ierr.UserExists) {
+		return err
+	}
+	return nil
+}
+
+func (c UserController) SetDisabled(ctx context.Context, id int64, disabled bool) error {
+	user, err := c.getUser(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	user.Disabled = disabled
+	return c.updateAndRevoke(ctx, user)
+}
+
+func (c UserController) ResetPassword(ctx context.Context, id int64, password string) error {
+	user, err := c.getUser(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if user.PasswordHash, err = hashPassword(password); err != nil {
+		return err
+	}
+	return c.updateAndRevoke(ctx, user)
+}
+
+// updateAndRevoke updates user and revokes its refresh tokens, so that sessions started
+// with old credentials can't be refreshed.
+func (c UserController) updateAndRevoke(ctx context.Context, user *model.User) error {
+	return c.transactor.WithTx(ctx, func(ctx context.Context) error {
+		if err := c.usersStorage.Update(ctx, user); err != nil {
+			return err
+		}
+		return c.tokensStorage.RevokeUserRefreshTokens(ctx, user.ID)
+	})
+}
+
+func (c UserController) getUser(ctx context.Context, id int64) (*model.User, error) {
+	user, err := c.usersStorage.GetByFilter(ctx, dataprovider.NewUserFilter().ByIDs(id))
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ierr.UserNotFound
+	}
+	return user, nil
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", errors.Wrap(ierr.InvalidParam, "password")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", errors.Wrap(err, "hashing password")
+	}
+	return string(hash), nil
+}
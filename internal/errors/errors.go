@@ -8,4 +8,14 @@ var (
 	WrongRequest    = errors.New("Wrong request format")
 	CompanyExists   = errors.New("Company with same code already exists")
 	UnknownLocation = errors.New("Location of request undefined")
+	VersionMismatch = errors.New("Company was modified by another request")
+	VersionRequired = errors.New("If-Match header is required")
+	PatchTestFailed = errors.New("Patch test operation failed")
+	UnsupportedType = errors.New("Unsupported content type")
+	BatchAborted    = errors.New("Batch aborted due to failed items")
+
+	UserNotFound       = errors.New("User not found")
+	UserExists         = errors.New("User with same username already exists")
+	InvalidCredentials = errors.New("Invalid username or password")
+	InvalidToken       = errors.New("Invalid token")
 )
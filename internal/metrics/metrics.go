@@ -0,0 +1,111 @@
+// Package metrics holds Prometheus collectors of the service, they are exposed by Handler.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+)
+
+const namespace = "companies"
+
+var (
+	// HTTPRequests counts served requests by method, chi route pattern and status.
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Number of served HTTP requests.",
+	}, []string{"method", "route", "status"})
+
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of served HTTP requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// DBQueryDuration measures storage methods by store and method names.
+	DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "db",
+		Name:      "query_duration_seconds",
+		Help:      "Latency of storage queries.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"store", "method"})
+
+	// MQPublished counts published messages by event type and result, either success or failure.
+	MQPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "mq",
+		Name:      "published_total",
+		Help:      "Number of messages published to the message queue.",
+	}, []string{"type", "result"})
+
+	// MQConnected is 1 while connection to the broker is open.
+	MQConnected = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "mq",
+		Name:      "connected",
+		Help:      "Whether connection to the message queue is open.",
+	})
+
+	MQReconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "mq",
+		Name:      "reconnects_total",
+		Help:      "Number of attempts to connect to the message queue after connection loss.",
+	})
+
+	LocationLookupDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "location",
+		Name:      "lookup_duration_seconds",
+		Help:      "Latency of client location lookups by provider.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"provider"})
+
+	// LocationLookupErrors counts failed lookups, unknown locations are not errors.
+	LocationLookupErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "location",
+		Name:      "lookup_errors_total",
+		Help:      "Number of failed client location lookups by provider.",
+	}, []string{"provider"})
+)
+
+// Results of MQPublished.
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Handler serves metrics of the default registry.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// RegisterLocationCache exposes counters of location cache given by stats.
+func RegisterLocationCache(stats func() (hits, negativeHits, misses uint64)) {
+	counter := func(name, help string, value func(hits, negativeHits, misses uint64) uint64) prometheus.CounterFunc {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "location",
+			Name:      name,
+			Help:      help,
+		}, func() float64 {
+			return float64(value(stats()))
+		})
+	}
+
+	prometheus.MustRegister(
+		counter("cache_hits_total", "Number of locations found in cache.",
+			func(hits, _, _ uint64) uint64 { return hits }),
+		counter("cache_negative_hits_total", "Number of unknown locations found in cache.",
+			func(_, negativeHits, _ uint64) uint64 { return negativeHits }),
+		counter("cache_misses_total", "Number of locations missing in cache.",
+			func(_, _, misses uint64) uint64 { return misses }),
+	)
+}
@@ -16,8 +16,15 @@ type Company struct {
 	Phone     string     `json:"phone" db:"phone"`
 	CreatedAt time.Time  `json:"created_at" db:"created_at"`
 	UpdatedAt *time.Time `json:"updated_at" db:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	// Version is incremented on every change, it's sent in ETag header.
+	Version int64 `json:"version" db:"version"`
+
+	// Relevance is a rank of search match, it's set by search only.
+	Relevance float64 `json:"-" db:"relevance"`
 }
 
+// CheckFields validates company of create and update requests, see patchableFields.
 func (c *Company) CheckFields() error {
 	if emptyString(c.Name) {
 		return errors.Wrap(ierr.InvalidParam, "name")
@@ -35,9 +42,7 @@ func (c *Company) CheckFields() error {
 		return errors.Wrap(ierr.InvalidParam, "website")
 	}
 
-	if emptyString(c.Phone) {
-		return errors.Wrap(ierr.InvalidParam, "phone")
-	}
+	// phone is optional, the same way as it may be removed by patch
 	return nil
 }
 
@@ -45,10 +50,11 @@ func emptyString(s string) bool {
 	return len(strings.TrimSpace(s)) == 0
 }
 
+// Equal compares fields changed by requests, country and website are stored lower case, so their case is ignored.
 func (c *Company) Equal(other *Company) bool {
 	return c.Name == other.Name &&
 		c.Code == other.Code &&
-		c.Country == other.Country &&
-		c.Website == other.Website &&
+		strings.EqualFold(c.Country, other.Country) &&
+		strings.EqualFold(c.Website, other.Website) &&
 		c.Phone == other.Phone
 }
@@ -0,0 +1,93 @@
+package model
+
+import (
+	"crypto/rand"
+	"fmt"
+	"time"
+)
+
+// Company event types.
+const (
+	EventCompanyCreated  = "company.created"
+	EventCompanyUpdated  = "company.updated"
+	EventCompanyDeleted  = "company.deleted"
+	EventCompanyRestored = "company.restored"
+
+	// EventCompanyBatch is a type of CompanyBatchEvent.
+	EventCompanyBatch = "company.batch"
+)
+
+// CompanyEvent describes a single change of a company.
+type CompanyEvent struct {
+	ID         string                 `json:"event_id"`
+	Type       string                 `json:"type"`
+	OccurredAt time.Time              `json:"occurred_at"`
+	Actor      string                 `json:"actor,omitempty"`
+	Company    *Company               `json:"company"`
+	Changes    map[string]FieldChange `json:"changes,omitempty"`
+}
+
+// CompanyBatchEvent describes changes of companies made by a single batch operation,
+// Operation is a type of every event in the batch.
+type CompanyBatchEvent struct {
+	ID         string          `json:"event_id"`
+	Operation  string          `json:"operation"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Actor      string          `json:"actor,omitempty"`
+	Events     []*CompanyEvent `json:"events"`
+}
+
+// FieldChange holds values of a company field before and after the change.
+type FieldChange struct {
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// NewCompanyEvent creates event of given type occurred now.
+func NewCompanyEvent(eventType, actor string, company *Company) *CompanyEvent {
+	return &CompanyEvent{
+		ID:         NewUUID(),
+		Type:       eventType,
+		OccurredAt: time.Now().UTC(),
+		Actor:      actor,
+		Company:    company,
+	}
+}
+
+// NewCompanyBatchEvent creates empty batch of events of given type occurred now.
+func NewCompanyBatchEvent(operation, actor string) *CompanyBatchEvent {
+	return &CompanyBatchEvent{
+		ID:         NewUUID(),
+		Operation:  operation,
+		OccurredAt: time.Now().UTC(),
+		Actor:      actor,
+	}
+}
+
+// Diff returns fields changed between old and c.
+func (c *Company) Diff(old *Company) map[string]FieldChange {
+	changes := make(map[string]FieldChange)
+	add := func(field, o, n string) {
+		if o != n {
+			changes[field] = FieldChange{Old: o, New: n}
+		}
+	}
+
+	add("name", old.Name, c.Name)
+	add("code", old.Code, c.Code)
+	add("country", old.Country, c.Country)
+	add("website", old.Website, c.Website)
+	add("phone", old.Phone, c.Phone)
+
+	return changes
+}
+
+// NewUUID generates random UUID v4.
+func NewUUID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// OutboxMessage is an event stored in the same transaction as the change it describes,
+// it is published to the message queue afterwards. Headers is JSON object of trace context
+// of the change, it's nil for messages stored before tracing was added.
+type OutboxMessage struct {
+	ID        int64     `db:"id"`
+	EventType string    `db:"event_type"`
+	Payload   []byte    `db:"payload"`
+	Headers   []byte    `db:"headers"`
+	Attempts  int       `db:"attempts"`
+	CreatedAt time.Time `db:"created_at"`
+}
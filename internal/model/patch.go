@@ -0,0 +1,217 @@
+package model
+
+import (
+	"encoding/json"
+	ierr "github.com/IakimenkoD/xm-companies-service/internal/errors"
+	"github.com/pkg/errors"
+	"reflect"
+	"strings"
+)
+
+// Media types of company patches.
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+// patchableFields are company fields which may be changed by patch, fields allowed to be removed are true.
+var patchableFields = map[string]bool{
+	"name":    false,
+	"code":    false,
+	"country": false,
+	"website": false,
+	"phone":   true,
+}
+
+// Patch changes JSON document of a company.
+type Patch interface {
+	apply(doc map[string]interface{}) error
+}
+
+// ApplyPatch returns copy of the company changed by patch, the result is validated before it's returned.
+func (c *Company) ApplyPatch(patch Patch) (*Company, error) {
+	raw, err := json.Marshal(c)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshalling company")
+	}
+
+	doc := make(map[string]interface{})
+	if err = json.Unmarshal(raw, &doc); err != nil {
+		return nil, errors.Wrap(err, "unmarshalling company")
+	}
+
+	if err = patch.apply(doc); err != nil {
+		return nil, err
+	}
+
+	patched := *c
+	fields := map[string]*string{
+		"name":    &patched.Name,
+		"code":    &patched.Code,
+		"country": &patched.Country,
+		"website": &patched.Website,
+		"phone":   &patched.Phone,
+	}
+	for field, dst := range fields {
+		switch v := doc[field].(type) {
+		case string:
+			*dst = v
+		case nil:
+			if !patchableFields[field] {
+				return nil, errors.Wrap(ierr.InvalidParam, field)
+			}
+			*dst = ""
+		default:
+			return nil, errors.Wrap(ierr.InvalidParam, field)
+		}
+	}
+
+	for field, removable := range patchableFields {
+		if !removable && emptyString(*fields[field]) {
+			return nil, errors.Wrap(ierr.InvalidParam, field)
+		}
+	}
+
+	return &patched, nil
+}
+
+// MergePatch is a JSON Merge Patch (RFC 7396), null value removes the field. Read-only fields, e.g. id
+// or version, are ignored if their values are unchanged, so a company got by GET may be sent back.
+type MergePatch map[string]interface{}
+
+func (p MergePatch) apply(doc map[string]interface{}) error {
+	for field, value := range p {
+		if _, ok := patchableFields[field]; ok {
+			continue
+		}
+		current, ok := doc[field]
+		if !ok && value == nil {
+			continue
+		}
+		if !ok || !reflect.DeepEqual(current, value) {
+			return errors.Wrap(ierr.InvalidParam, field)
+		}
+	}
+
+	for field, value := range p {
+		if _, ok := patchableFields[field]; !ok {
+			continue
+		}
+		if value == nil {
+			delete(doc, field)
+			continue
+		}
+		doc[field] = mergeValue(doc[field], value)
+	}
+
+	return nil
+}
+
+func mergeValue(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = make(map[string]interface{})
+	}
+	for k, v := range patchObj {
+		if v == nil {
+			delete(targetObj, k)
+			continue
+		}
+		targetObj[k] = mergeValue(targetObj[k], v)
+	}
+
+	return targetObj
+}
+
+// JSONPatch is a JSON Patch (RFC 6902), company is a flat document so only top level paths are valid.
+type JSONPatch []PatchOperation
+
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+func (p JSONPatch) apply(doc map[string]interface{}) error {
+	for n, op := range p {
+		if err := op.apply(doc); err != nil {
+			return errors.Wrapf(err, "operation #%d", n)
+		}
+	}
+	return nil
+}
+
+func (op PatchOperation) apply(doc map[string]interface{}) error {
+	field, err := patchPointer(op.Path, op.Op != "test")
+	if err != nil {
+		return err
+	}
+
+	var value interface{}
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return errors.Wrap(ierr.InvalidParam, "value")
+		}
+		if err = json.Unmarshal(op.Value, &value); err != nil {
+			return errors.Wrap(ierr.InvalidParam, "value")
+		}
+	case "move", "copy":
+		from, err := patchPointer(op.From, op.Op == "move")
+		if err != nil {
+			return err
+		}
+		v, ok := doc[from]
+		if !ok {
+			return errors.Wrap(ierr.InvalidParam, "from")
+		}
+		if op.Op == "move" {
+			delete(doc, from)
+		}
+		value = v
+	}
+
+	_, exists := doc[field]
+	switch op.Op {
+	case "add", "move", "copy":
+		doc[field] = value
+	case "replace":
+		if !exists {
+			return errors.Wrap(ierr.InvalidParam, "path")
+		}
+		doc[field] = value
+	case "remove":
+		if !exists {
+			return errors.Wrap(ierr.InvalidParam, "path")
+		}
+		delete(doc, field)
+	case "test":
+		if !exists || !reflect.DeepEqual(doc[field], value) {
+			return errors.Wrap(ierr.PatchTestFailed, op.Path)
+		}
+	default:
+		return errors.Wrap(ierr.InvalidParam, "op")
+	}
+
+	return nil
+}
+
+// patchPointer returns field name JSON pointer refers to, only patchable fields may be changed.
+func patchPointer(pointer string, change bool) (string, error) {
+	if !strings.HasPrefix(pointer, "/") || strings.Count(pointer, "/") > 1 {
+		return "", errors.Wrap(ierr.InvalidParam, "path")
+	}
+
+	field := strings.NewReplacer("~1", "/", "~0", "~").Replace(pointer[1:])
+	if _, ok := patchableFields[field]; change && !ok {
+		return "", errors.Wrap(ierr.InvalidParam, field)
+	}
+
+	return field, nil
+}
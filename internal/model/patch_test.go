@@ -0,0 +1,114 @@
+package model
+
+import (
+	"encoding/json"
+	ierr "github.com/IakimenkoD/xm-companies-service/internal/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestCompany_ApplyMergePatch(t *testing.T) {
+	created := time.Date(2026, 10, 1, 10, 0, 0, 123456000, time.UTC)
+	company := &Company{
+		ID:        12,
+		Name:      "Meta",
+		Code:      "1234",
+		Country:   "CY",
+		Website:   "meta.com",
+		Phone:     "+35799000000",
+		CreatedAt: created,
+		Version:   3,
+	}
+
+	tt := []struct {
+		name     string
+		patch    string
+		expected *Company
+		err      string
+	}{
+		{
+			name:  "changes field",
+			patch: `{"name": "Facebook"}`,
+			expected: &Company{ID: 12, Name: "Facebook", Code: "1234", Country: "CY", Website: "meta.com",
+				Phone: "+35799000000", CreatedAt: created, Version: 3},
+		},
+		{
+			name:  "null removes phone",
+			patch: `{"phone": null}`,
+			expected: &Company{ID: 12, Name: "Meta", Code: "1234", Country: "CY", Website: "meta.com",
+				CreatedAt: created, Version: 3},
+		},
+		{
+			name:  "unchanged read-only fields are ignored",
+			patch: `{"id": 12, "version": 3, "created_at": "2026-10-01T10:00:00.123456Z", "updated_at": null, "deleted_at": null, "website": "fb.com"}`,
+			expected: &Company{ID: 12, Name: "Meta", Code: "1234", Country: "CY", Website: "fb.com",
+				Phone: "+35799000000", CreatedAt: created, Version: 3},
+		},
+		{
+			name:  "fail: changed id",
+			patch: `{"id": 13}`,
+			err:   "id: " + ierr.InvalidParam.Error(),
+		},
+		{
+			name:  "fail: changed version",
+			patch: `{"version": 4, "name": "Facebook"}`,
+			err:   "version: " + ierr.InvalidParam.Error(),
+		},
+		{
+			name:  "fail: deleted_at set",
+			patch: `{"deleted_at": "2026-10-02T00:00:00Z"}`,
+			err:   "deleted_at: " + ierr.InvalidParam.Error(),
+		},
+		{
+			name:  "fail: unknown field",
+			patch: `{"owner": "me"}`,
+			err:   "owner: " + ierr.InvalidParam.Error(),
+		},
+		{
+			name:  "fail: required field removed",
+			patch: `{"code": null}`,
+			err:   "code: " + ierr.InvalidParam.Error(),
+		},
+		{
+			name:  "fail: required field emptied",
+			patch: `{"name": " "}`,
+			err:   "name: " + ierr.InvalidParam.Error(),
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			patch := MergePatch{}
+			require.NoError(t, json.Unmarshal([]byte(tc.patch), &patch))
+
+			patched, err := company.ApplyPatch(patch)
+			if tc.err != "" {
+				assert.ErrorIs(t, err, ierr.InvalidParam)
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, patched)
+		})
+	}
+}
+
+func TestCompany_CheckFields(t *testing.T) {
+	company := &Company{Name: "Meta", Code: "1234", Country: "CY", Website: "meta.com"}
+	assert.NoError(t, company.CheckFields(), "phone is optional")
+
+	company.Website = ""
+	assert.EqualError(t, company.CheckFields(), "website: "+ierr.InvalidParam.Error())
+}
+
+func TestCompany_Equal(t *testing.T) {
+	stored := &Company{Name: "Meta", Code: "1234", Country: "cy", Website: "meta.com", Phone: "+001"}
+
+	assert.True(t, stored.Equal(&Company{Name: "Meta", Code: "1234", Country: "CY", Website: "Meta.com", Phone: "+001"}),
+		"country and website are stored lower case")
+	assert.False(t, stored.Equal(&Company{Name: "META", Code: "1234", Country: "cy", Website: "meta.com", Phone: "+001"}))
+	assert.False(t, stored.Equal(&Company{Name: "Meta", Code: "1234", Country: "gr", Website: "meta.com", Phone: "+001"}))
+}
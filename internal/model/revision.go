@@ -0,0 +1,19 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// CompanyRevision is an audit record of a company change, Before is null for created
+// companies and After is null for deleted ones.
+type CompanyRevision struct {
+	ID        int64           `json:"id" db:"id"`
+	CompanyID int64           `json:"company_id" db:"company_id"`
+	Action    string          `json:"action" db:"action"`
+	Actor     string          `json:"actor" db:"actor"`
+	ClientIP  string          `json:"client_ip" db:"client_ip"`
+	Before    json.RawMessage `json:"before" db:"before"`
+	After     json.RawMessage `json:"after" db:"after"`
+	CreatedAt time.Time       `json:"created_at" db:"created_at"`
+}
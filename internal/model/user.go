@@ -0,0 +1,54 @@
+package model
+
+import (
+	ierr "github.com/IakimenkoD/xm-companies-service/internal/errors"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// User roles.
+const (
+	RoleViewer = "viewer"
+	RoleUser   = "user"
+	RoleAdmin  = "admin"
+)
+
+// Scopes granted by access tokens.
+const (
+	ScopeCompaniesRead   = "companies:read"
+	ScopeCompaniesWrite  = "companies:write"
+	ScopeCompaniesDelete = "companies:delete"
+	ScopeAdmin           = "admin"
+)
+
+var roleScopes = map[string][]string{
+	RoleViewer: {ScopeCompaniesRead},
+	RoleUser:   {ScopeCompaniesRead, ScopeCompaniesWrite},
+	RoleAdmin:  {ScopeCompaniesRead, ScopeCompaniesWrite, ScopeCompaniesDelete, ScopeAdmin},
+}
+
+// RoleScopes returns scopes granted to the role.
+func RoleScopes(role string) []string {
+	return roleScopes[role]
+}
+
+type User struct {
+	ID           int64      `json:"id" db:"id"`
+	Username     string     `json:"username" db:"username"`
+	PasswordHash string     `json:"-" db:"password_hash"`
+	Role         string     `json:"role" db:"role"`
+	Disabled     bool       `json:"disabled" db:"disabled"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt    *time.Time `json:"updated_at" db:"updated_at"`
+}
+
+func (u *User) CheckFields() error {
+	if emptyString(u.Username) {
+		return errors.Wrap(ierr.InvalidParam, "username")
+	}
+
+	if _, ok := roleScopes[u.Role]; !ok {
+		return errors.Wrap(ierr.InvalidParam, "role")
+	}
+	return nil
+}
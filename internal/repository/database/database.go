@@ -54,3 +54,36 @@ func (db *Client) StatusCheck(ctx context.Context) error {
 	var ok bool
 	return db.QueryRowContext(ctx, `SELECT true`).Scan(&ok)
 }
+
+type txKey struct{}
+
+// WithTx runs fn in a transaction, storages called with ctx passed to fn take part in it.
+// Nested calls reuse the outer transaction.
+func (db *Client) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*sqlx.Tx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "can't begin transaction")
+	}
+
+	if err = fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return errors.Wrapf(err, "rollback failed: %v", rbErr)
+		}
+		return err
+	}
+
+	return errors.Wrap(tx.Commit(), "can't commit transaction")
+}
+
+// Conn returns transaction started by WithTx or the database itself, queries are traced.
+func (db *Client) Conn(ctx context.Context) sqlx.ExtContext {
+	if tx, ok := ctx.Value(txKey{}).(*sqlx.Tx); ok {
+		return tracedConn{tx}
+	}
+
+	return tracedConn{db.DB}
+}
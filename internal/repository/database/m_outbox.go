@@ -0,0 +1,37 @@
+package database
+
+import (
+	"database/sql"
+	"github.com/lopezator/migrator"
+	"github.com/pkg/errors"
+)
+
+func migrationOutbox(schema string) *migrator.Migration {
+	return &migrator.Migration{
+		Name: "outbox",
+		Func: func(tx *sql.Tx) error {
+			qs := []string{
+				`CREATE TABLE IF NOT EXISTS ` + schema + `.outbox (` +
+					`id BIGSERIAL PRIMARY KEY` +
+					`, event_type VARCHAR NOT NULL` +
+					`, payload JSONB NOT NULL` +
+					`, attempts INTEGER NOT NULL DEFAULT 0` +
+					`, last_error VARCHAR` +
+					`, next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW()` +
+					`, created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()` +
+					`)`,
+				`CREATE INDEX IF NOT EXISTS outbox_next_attempt_at_idx ON ` + schema + `.outbox (next_attempt_at)`,
+			}
+			for k, query := range qs {
+				if _, err := tx.Exec(query); err != nil {
+					return errors.Wrapf(err, "applying outbox migration #%d", k)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+/* ROLLBACK SQL
+DROP TABLE IF EXISTS xm.outbox;
+*/
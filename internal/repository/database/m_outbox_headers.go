@@ -0,0 +1,28 @@
+package database
+
+import (
+	"database/sql"
+	"github.com/lopezator/migrator"
+	"github.com/pkg/errors"
+)
+
+func migrationOutboxHeaders(schema string) *migrator.Migration {
+	return &migrator.Migration{
+		Name: "outbox_headers",
+		Func: func(tx *sql.Tx) error {
+			qs := []string{
+				`ALTER TABLE ` + schema + `.outbox ADD COLUMN IF NOT EXISTS headers JSONB`,
+			}
+			for k, query := range qs {
+				if _, err := tx.Exec(query); err != nil {
+					return errors.Wrapf(err, "applying outbox_headers migration #%d", k)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+/* ROLLBACK SQL
+ALTER TABLE xm.outbox DROP COLUMN IF EXISTS headers;
+*/
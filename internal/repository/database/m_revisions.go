@@ -0,0 +1,38 @@
+package database
+
+import (
+	"database/sql"
+	"github.com/lopezator/migrator"
+	"github.com/pkg/errors"
+)
+
+func migrationRevisions(schema string) *migrator.Migration {
+	return &migrator.Migration{
+		Name: "revisions",
+		Func: func(tx *sql.Tx) error {
+			qs := []string{
+				`CREATE TABLE IF NOT EXISTS ` + schema + `.company_revisions (` +
+					`id BIGSERIAL PRIMARY KEY` +
+					`, company_id BIGINT NOT NULL` +
+					`, action VARCHAR NOT NULL` +
+					`, actor VARCHAR NOT NULL DEFAULT ''` +
+					`, client_ip VARCHAR NOT NULL DEFAULT ''` +
+					`, before JSONB` +
+					`, after JSONB` +
+					`, created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()` +
+					`)`,
+				`CREATE INDEX IF NOT EXISTS company_revisions_company_id_idx ON ` + schema + `.company_revisions (company_id, id)`,
+			}
+			for k, query := range qs {
+				if _, err := tx.Exec(query); err != nil {
+					return errors.Wrapf(err, "applying revisions migration #%d", k)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+/* ROLLBACK SQL
+DROP TABLE IF EXISTS xm.company_revisions;
+*/
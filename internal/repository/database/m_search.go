@@ -0,0 +1,34 @@
+package database
+
+import (
+	"database/sql"
+	"github.com/lopezator/migrator"
+	"github.com/pkg/errors"
+)
+
+func migrationSearch(schema string) *migrator.Migration {
+	return &migrator.Migration{
+		Name: "search",
+		Func: func(tx *sql.Tx) error {
+			qs := []string{
+				`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+				`ALTER TABLE ` + schema + `.companies ADD COLUMN IF NOT EXISTS search_vector TSVECTOR ` +
+					`GENERATED ALWAYS AS (to_tsvector('simple', name || ' ' || code || ' ' || website)) STORED`,
+				`CREATE INDEX IF NOT EXISTS companies_search_vector_idx ON ` + schema + `.companies USING GIN (search_vector)`,
+				`CREATE INDEX IF NOT EXISTS companies_name_trgm_idx ON ` + schema + `.companies USING GIN (name gin_trgm_ops)`,
+			}
+			for k, query := range qs {
+				if _, err := tx.Exec(query); err != nil {
+					return errors.Wrapf(err, "applying search migration #%d", k)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+/* ROLLBACK SQL
+DROP INDEX IF EXISTS xm.companies_name_trgm_idx;
+DROP INDEX IF EXISTS xm.companies_search_vector_idx;
+ALTER TABLE xm.companies DROP COLUMN IF EXISTS search_vector;
+*/
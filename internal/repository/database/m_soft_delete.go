@@ -0,0 +1,36 @@
+package database
+
+import (
+	"database/sql"
+	"github.com/lopezator/migrator"
+	"github.com/pkg/errors"
+)
+
+func migrationSoftDelete(schema string) *migrator.Migration {
+	return &migrator.Migration{
+		Name: "soft_delete",
+		Func: func(tx *sql.Tx) error {
+			qs := []string{
+				`ALTER TABLE ` + schema + `.companies ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ`,
+				// code of a deleted company may be taken by a new one
+				`ALTER TABLE ` + schema + `.companies DROP CONSTRAINT IF EXISTS companies_code_key`,
+				`CREATE UNIQUE INDEX IF NOT EXISTS companies_code_idx ON ` + schema + `.companies (code) WHERE deleted_at IS NULL`,
+				`CREATE INDEX IF NOT EXISTS companies_deleted_at_idx ON ` + schema + `.companies (deleted_at) WHERE deleted_at IS NOT NULL`,
+			}
+			for k, query := range qs {
+				if _, err := tx.Exec(query); err != nil {
+					return errors.Wrapf(err, "applying soft delete migration #%d", k)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+/* ROLLBACK SQL
+DROP INDEX IF EXISTS xm.companies_deleted_at_idx;
+DROP INDEX IF EXISTS xm.companies_code_idx;
+DELETE FROM xm.companies WHERE deleted_at IS NOT NULL;
+ALTER TABLE xm.companies ADD CONSTRAINT companies_code_key UNIQUE (code);
+ALTER TABLE xm.companies DROP COLUMN IF EXISTS deleted_at;
+*/
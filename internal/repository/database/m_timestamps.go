@@ -0,0 +1,30 @@
+package database
+
+import (
+	"database/sql"
+	"github.com/lopezator/migrator"
+	"github.com/pkg/errors"
+)
+
+func migrationTimestampIndexes(schema string) *migrator.Migration {
+	return &migrator.Migration{
+		Name: "timestamp_indexes",
+		Func: func(tx *sql.Tx) error {
+			qs := []string{
+				`CREATE INDEX IF NOT EXISTS companies_created_at_idx ON ` + schema + `.companies (created_at)`,
+				`CREATE INDEX IF NOT EXISTS companies_changed_at_idx ON ` + schema + `.companies ((COALESCE(updated_at, created_at)))`,
+			}
+			for k, query := range qs {
+				if _, err := tx.Exec(query); err != nil {
+					return errors.Wrapf(err, "applying timestamp indexes migration #%d", k)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+/* ROLLBACK SQL
+DROP INDEX IF EXISTS xm.companies_changed_at_idx;
+DROP INDEX IF EXISTS xm.companies_created_at_idx;
+*/
@@ -0,0 +1,41 @@
+package database
+
+import (
+	"database/sql"
+	"github.com/lopezator/migrator"
+	"github.com/pkg/errors"
+)
+
+func migrationTokens(schema string) *migrator.Migration {
+	return &migrator.Migration{
+		Name: "tokens",
+		Func: func(tx *sql.Tx) error {
+			qs := []string{
+				`CREATE TABLE IF NOT EXISTS ` + schema + `.refresh_tokens (` +
+					`id BIGSERIAL PRIMARY KEY` +
+					`, user_id BIGINT NOT NULL REFERENCES ` + schema + `.users (id) ON DELETE CASCADE` +
+					`, token_hash VARCHAR NOT NULL UNIQUE` +
+					`, expires_at TIMESTAMPTZ NOT NULL` +
+					`, revoked_at TIMESTAMPTZ` +
+					`, created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()` +
+					`)`,
+				`CREATE INDEX IF NOT EXISTS refresh_tokens_user_id_idx ON ` + schema + `.refresh_tokens (user_id)`,
+				`CREATE TABLE IF NOT EXISTS ` + schema + `.revoked_tokens (` +
+					`jti VARCHAR PRIMARY KEY` +
+					`, expires_at TIMESTAMPTZ NOT NULL` +
+					`)`,
+			}
+			for k, query := range qs {
+				if _, err := tx.Exec(query); err != nil {
+					return errors.Wrapf(err, "applying tokens migration #%d", k)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+/* ROLLBACK SQL
+DROP TABLE IF EXISTS xm.revoked_tokens;
+DROP TABLE IF EXISTS xm.refresh_tokens;
+*/
@@ -0,0 +1,36 @@
+package database
+
+import (
+	"database/sql"
+	"github.com/lopezator/migrator"
+	"github.com/pkg/errors"
+)
+
+func migrationUsers(schema string) *migrator.Migration {
+	return &migrator.Migration{
+		Name: "users",
+		Func: func(tx *sql.Tx) error {
+			qs := []string{
+				`CREATE TABLE IF NOT EXISTS ` + schema + `.users (` +
+					`id BIGSERIAL PRIMARY KEY` +
+					`, username VARCHAR NOT NULL UNIQUE` +
+					`, password_hash VARCHAR NOT NULL` +
+					`, role VARCHAR NOT NULL DEFAULT 'user'` +
+					`, disabled BOOLEAN NOT NULL DEFAULT FALSE` +
+					`, created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()` +
+					`, updated_at TIMESTAMPTZ` +
+					`)`,
+			}
+			for k, query := range qs {
+				if _, err := tx.Exec(query); err != nil {
+					return errors.Wrapf(err, "applying users migration #%d", k)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+/* ROLLBACK SQL
+DROP TABLE IF EXISTS xm.users;
+*/
@@ -0,0 +1,28 @@
+package database
+
+import (
+	"database/sql"
+	"github.com/lopezator/migrator"
+	"github.com/pkg/errors"
+)
+
+func migrationVersion(schema string) *migrator.Migration {
+	return &migrator.Migration{
+		Name: "version",
+		Func: func(tx *sql.Tx) error {
+			qs := []string{
+				`ALTER TABLE ` + schema + `.companies ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1`,
+			}
+			for k, query := range qs {
+				if _, err := tx.Exec(query); err != nil {
+					return errors.Wrapf(err, "applying version migration #%d", k)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+/* ROLLBACK SQL
+ALTER TABLE xm.companies DROP COLUMN IF EXISTS version;
+*/
@@ -20,6 +20,15 @@ func migrations(schema, migrationsTable string) (*migrator.Migrator, error) {
 		migrator.TableName(fmt.Sprintf("%s.%s", schema, migrationsTable)),
 		migrator.Migrations(
 			migrationInit(schema),
+			migrationOutbox(schema),
+			migrationUsers(schema),
+			migrationTokens(schema),
+			migrationSearch(schema),
+			migrationTimestampIndexes(schema),
+			migrationSoftDelete(schema),
+			migrationRevisions(schema),
+			migrationVersion(schema),
+			migrationOutboxHeaders(schema),
 		),
 	)
 }
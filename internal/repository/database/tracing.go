@@ -0,0 +1,57 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"github.com/IakimenkoD/xm-companies-service/internal/tracing"
+	"github.com/jmoiron/sqlx"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedConn creates client span of every query executed by conn in a traced context. Queries
+// out of any trace, e.g. polling of background workers, don't start new traces.
+type tracedConn struct {
+	sqlx.ExtContext
+}
+
+func (c tracedConn) start(ctx context.Context, query string) (context.Context, trace.Span) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	return tracing.Start(ctx, "postgres",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBStatementKey.String(query),
+		),
+	)
+}
+
+func (c tracedConn) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := c.start(ctx, query)
+	rows, err := c.ExtContext.QueryContext(ctx, query, args...)
+	tracing.End(span, err)
+	return rows, err
+}
+
+func (c tracedConn) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	ctx, span := c.start(ctx, query)
+	rows, err := c.ExtContext.QueryxContext(ctx, query, args...)
+	tracing.End(span, err)
+	return rows, err
+}
+
+func (c tracedConn) QueryRowxContext(ctx context.Context, query string, args ...interface{}) *sqlx.Row {
+	ctx, span := c.start(ctx, query)
+	row := c.ExtContext.QueryRowxContext(ctx, query, args...)
+	tracing.End(span, row.Err())
+	return row
+}
+
+func (c tracedConn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := c.start(ctx, query)
+	res, err := c.ExtContext.ExecContext(ctx, query, args...)
+	tracing.End(span, err)
+	return res, err
+}
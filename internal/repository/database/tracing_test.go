@@ -0,0 +1,120 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"github.com/IakimenkoD/xm-companies-service/internal/tracing"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"testing"
+)
+
+// stubConn runs no queries, it keeps span context of the last query and fails queries with err.
+type stubConn struct {
+	sqlx.ExtContext
+	err  error
+	span trace.SpanContext
+}
+
+func (c *stubConn) QueryContext(ctx context.Context, _ string, _ ...interface{}) (*sql.Rows, error) {
+	c.span = trace.SpanContextFromContext(ctx)
+	return nil, c.err
+}
+
+func (c *stubConn) QueryxContext(ctx context.Context, _ string, _ ...interface{}) (*sqlx.Rows, error) {
+	c.span = trace.SpanContextFromContext(ctx)
+	return nil, c.err
+}
+
+func (c *stubConn) QueryRowxContext(ctx context.Context, _ string, _ ...interface{}) *sqlx.Row {
+	c.span = trace.SpanContextFromContext(ctx)
+	return &sqlx.Row{}
+}
+
+func (c *stubConn) ExecContext(ctx context.Context, _ string, _ ...interface{}) (sql.Result, error) {
+	c.span = trace.SpanContextFromContext(ctx)
+	return nil, c.err
+}
+
+func TestTracedConn(t *testing.T) {
+	const query = "SELECT 1"
+	failure := errors.New("connection reset")
+
+	tt := []struct {
+		name  string
+		err   error
+		query func(ctx context.Context, conn tracedConn)
+	}{
+		{
+			name: "query",
+			query: func(ctx context.Context, conn tracedConn) {
+				_, _ = conn.QueryContext(ctx, query)
+			},
+		},
+		{
+			name: "queryx",
+			query: func(ctx context.Context, conn tracedConn) {
+				_, _ = conn.QueryxContext(ctx, query)
+			},
+		},
+		{
+			name: "query row",
+			query: func(ctx context.Context, conn tracedConn) {
+				_ = conn.QueryRowxContext(ctx, query)
+			},
+		},
+		{
+			name: "exec",
+			query: func(ctx context.Context, conn tracedConn) {
+				_, _ = conn.ExecContext(ctx, query)
+			},
+		},
+		{
+			name: "failed exec",
+			err:  failure,
+			query: func(ctx context.Context, conn tracedConn) {
+				_, _ = conn.ExecContext(ctx, query)
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := tracing.Record()
+			stub := &stubConn{err: tc.err}
+			conn := tracedConn{stub}
+
+			ctx, parent := tracing.Start(context.Background(), "request")
+			tc.query(ctx, conn)
+			tc.query(ctx, conn)
+			parent.End()
+
+			spans := recorder.Ended()
+			require.Len(t, spans, 3, "a span per query and the parent one")
+			for _, span := range spans[:2] {
+				assert.Equal(t, "postgres", span.Name())
+				assert.Equal(t, trace.SpanKindClient, span.SpanKind())
+				assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+				assert.Contains(t, span.Attributes(), semconv.DBStatementKey.String(query))
+				if tc.err != nil {
+					assert.Equal(t, codes.Error, span.Status().Code)
+					assert.Equal(t, tc.err.Error(), span.Status().Description)
+				} else {
+					assert.Equal(t, codes.Unset, span.Status().Code)
+				}
+			}
+			assert.Equal(t, spans[1].SpanContext().SpanID(), stub.span.SpanID(), "query runs in its span")
+
+			// queries out of any trace, e.g. of background workers, don't start new traces
+			tc.query(context.Background(), conn)
+			assert.Len(t, recorder.Ended(), 3)
+			assert.False(t, stub.span.IsValid())
+		})
+	}
+}
@@ -3,18 +3,51 @@ package dataprovider
 import (
 	"context"
 	"github.com/IakimenkoD/xm-companies-service/internal/model"
+	"time"
 )
 
 //go:generate minimock -i CompaniesStorage -g -o companies_storage_mock.go
 type CompaniesStorage interface {
 	GetByFilter(ctx context.Context, filter *CompanyFilter) (*model.Company, error)
 	GetListByFilter(ctx context.Context, filter *CompanyFilter) ([]*model.Company, error)
-	DeleteByID(ctx context.Context, id int64) error
+	// DeleteByID moves company to trash, it's hidden from filters unless deleted companies are included.
+	DeleteByID(ctx context.Context, id, version int64) error
+	// RestoreByID takes company out of trash, CompanyExists is returned if another company has its code,
+	// CompanyNotFound if it isn't in trash anymore, e.g. it was purged or restored meanwhile.
+	RestoreByID(ctx context.Context, id int64) error
+	// PurgeDeleted removes companies deleted before given time for good.
+	PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int64, error)
 
+	// Insert returns CompanyExists if another company has the code, so do InsertBatch and Update.
 	Insert(ctx context.Context, company *model.Company) (int64, error)
+	// InsertBatch inserts companies with multi-row statements and sets their ids.
+	InsertBatch(ctx context.Context, companies []*model.Company) error
+	// Update replaces company fields if its version equals company.Version, 0 version matches any.
 	Update(ctx context.Context, company *model.Company) error
 }
 
+// Match modes of CompanyFilter.Query.
+const (
+	// MatchFullText matches words of name, code and website, results are ranked by ts_rank.
+	MatchFullText = "fulltext"
+	// MatchPrefix matches names starting with query case-insensitively.
+	MatchPrefix = "prefix"
+	// MatchContains matches names containing query case-insensitively.
+	MatchContains = "contains"
+	// MatchFuzzy matches names having a word similar to query, e.g. misspelled.
+	MatchFuzzy = "fuzzy"
+)
+
+// ValidMatch tells whether match is a known match mode.
+func ValidMatch(match string) bool {
+	switch match {
+	case MatchFullText, MatchPrefix, MatchContains, MatchFuzzy:
+		return true
+	default:
+		return false
+	}
+}
+
 // CompanyFilter is a filter for companies in storage.
 type CompanyFilter struct {
 	IDs       []int64
@@ -24,6 +57,21 @@ type CompanyFilter struct {
 	Countries []string
 	WebSites  []string
 	Phones    []string
+
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	UpdatedSince  time.Time
+
+	IncludeDeleted bool
+	OnlyDeleted    bool
+
+	// Query is searched in companies as specified by Match.
+	Query string
+	Match string
+
+	Sort   []SortField
+	Limit  uint64
+	Cursor *Cursor
 }
 
 func NewCompanyFilter() *CompanyFilter {
@@ -71,3 +119,58 @@ func (f *CompanyFilter) ByPhones(phones ...string) *CompanyFilter {
 	f.Phones = phones
 	return f
 }
+
+// ByCreatedAfter filters companies created later than t.
+func (f *CompanyFilter) ByCreatedAfter(t time.Time) *CompanyFilter {
+	f.CreatedAfter = t
+	return f
+}
+
+// ByCreatedBefore filters companies created earlier than t.
+func (f *CompanyFilter) ByCreatedBefore(t time.Time) *CompanyFilter {
+	f.CreatedBefore = t
+	return f
+}
+
+// ByUpdatedSince filters companies created or updated at t or later.
+func (f *CompanyFilter) ByUpdatedSince(t time.Time) *CompanyFilter {
+	f.UpdatedSince = t
+	return f
+}
+
+// WithDeleted includes deleted companies.
+func (f *CompanyFilter) WithDeleted(include bool) *CompanyFilter {
+	f.IncludeDeleted = include
+	return f
+}
+
+// ByDeleted filters deleted companies only.
+func (f *CompanyFilter) ByDeleted(only bool) *CompanyFilter {
+	f.OnlyDeleted = only
+	return f
+}
+
+// ByQuery searches companies matching query, see Match* constants for available modes.
+func (f *CompanyFilter) ByQuery(query, match string) *CompanyFilter {
+	f.Query = query
+	f.Match = match
+	return f
+}
+
+// OrderBy sets sorting of companies, xm.companies.id is always used as a tiebreaker.
+func (f *CompanyFilter) OrderBy(fields ...SortField) *CompanyFilter {
+	f.Sort = fields
+	return f
+}
+
+// WithLimit limits amount of companies returned, 0 means no limit.
+func (f *CompanyFilter) WithLimit(limit uint64) *CompanyFilter {
+	f.Limit = limit
+	return f
+}
+
+// After returns companies following the one cursor points to.
+func (f *CompanyFilter) After(cursor *Cursor) *CompanyFilter {
+	f.Cursor = cursor
+	return f
+}
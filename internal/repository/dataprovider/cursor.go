@@ -0,0 +1,170 @@
+package dataprovider
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	ierr "github.com/IakimenkoD/xm-companies-service/internal/errors"
+	"github.com/IakimenkoD/xm-companies-service/internal/model"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sortable fields of xm.companies.
+const (
+	SortByID        = "id"
+	SortByName      = "name"
+	SortByCode      = "code"
+	SortByCountry   = "country"
+	SortByWebsite   = "website"
+	SortByCreatedAt = "created_at"
+	// SortByRelevance orders by rank of CompanyFilter.Query match, it's available for search only.
+	SortByRelevance = "relevance"
+)
+
+var sortableFields = map[string]struct{}{
+	SortByID:        {},
+	SortByName:      {},
+	SortByCode:      {},
+	SortByCountry:   {},
+	SortByWebsite:   {},
+	SortByCreatedAt: {},
+	SortByRelevance: {},
+}
+
+// SortField is a single ordering key of companies list.
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// ParseSort parses sort expression like "name,-created_at".
+func ParseSort(expr string) ([]SortField, error) {
+	var fields []SortField
+	for _, s := range strings.Split(expr, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		field := SortField{Field: strings.TrimPrefix(s, "-"), Desc: strings.HasPrefix(s, "-")}
+		if _, ok := sortableFields[field.Field]; !ok {
+			return nil, errors.Wrap(ierr.InvalidParam, "sort")
+		}
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+// SortKeys returns ordering keys with xm.companies.id appended as a tiebreaker.
+func SortKeys(fields []SortField) []SortField {
+	for _, f := range fields {
+		if f.Field == SortByID {
+			return fields
+		}
+	}
+
+	keys := make([]SortField, 0, len(fields)+1)
+	keys = append(keys, fields...)
+
+	return append(keys, SortField{Field: SortByID})
+}
+
+func sortString(fields []SortField) string {
+	parts := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if f.Desc {
+			parts = append(parts, "-"+f.Field)
+			continue
+		}
+		parts = append(parts, f.Field)
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// Cursor points to the last company of a page for keyset pagination.
+type Cursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+}
+
+// NewCursor creates cursor pointing to the company for given ordering.
+func NewCursor(fields []SortField, last *model.Company) *Cursor {
+	keys := SortKeys(fields)
+	values := make([]string, 0, len(keys))
+	for _, k := range keys {
+		values = append(values, sortValue(last, k.Field))
+	}
+
+	return &Cursor{
+		Sort:   sortString(fields),
+		Values: values,
+	}
+}
+
+// DecodeCursor parses opaque token, created by Cursor.Encode, issued for given ordering.
+func DecodeCursor(token string, fields []SortField) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errors.Wrap(ierr.InvalidParam, "cursor")
+	}
+
+	c := &Cursor{}
+	if err = json.Unmarshal(raw, c); err != nil {
+		return nil, errors.Wrap(ierr.InvalidParam, "cursor")
+	}
+
+	if c.Sort != sortString(fields) || len(c.Values) != len(SortKeys(fields)) {
+		return nil, errors.Wrap(ierr.InvalidParam, "cursor does not match sort")
+	}
+
+	for n, k := range SortKeys(fields) {
+		if !validSortValue(k.Field, c.Values[n]) {
+			return nil, errors.Wrap(ierr.InvalidParam, "cursor")
+		}
+	}
+
+	return c, nil
+}
+
+// Encode returns opaque token to be passed to clients.
+func (c *Cursor) Encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func sortValue(c *model.Company, field string) string {
+	switch field {
+	case SortByName:
+		return c.Name
+	case SortByCode:
+		return c.Code
+	case SortByCountry:
+		return c.Country
+	case SortByWebsite:
+		return c.Website
+	case SortByCreatedAt:
+		return c.CreatedAt.UTC().Format(time.RFC3339Nano)
+	case SortByRelevance:
+		return strconv.FormatFloat(c.Relevance, 'f', -1, 64)
+	default:
+		return strconv.FormatInt(c.ID, 10)
+	}
+}
+
+func validSortValue(field, value string) bool {
+	var err error
+	switch field {
+	case SortByID:
+		_, err = strconv.ParseInt(value, 10, 64)
+	case SortByCreatedAt:
+		_, err = time.Parse(time.RFC3339Nano, value)
+	case SortByRelevance:
+		_, err = strconv.ParseFloat(value, 64)
+	}
+
+	return err == nil
+}
@@ -0,0 +1,26 @@
+package dataprovider
+
+import (
+	"context"
+	"github.com/IakimenkoD/xm-companies-service/internal/model"
+	"time"
+)
+
+//go:generate minimock -i OutboxStorage -g -o outbox_storage_mock.go
+type OutboxStorage interface {
+	Insert(ctx context.Context, msg *model.OutboxMessage) error
+	// ClaimPending returns messages ready to be published ordered by id, they aren't returned
+	// again until leaseUntil, so that they are published by a single relay.
+	ClaimPending(ctx context.Context, limit uint64, leaseUntil time.Time) ([]*model.OutboxMessage, error)
+	DeleteByID(ctx context.Context, id int64) error
+	MarkFailed(ctx context.Context, id int64, reason string, nextAttemptAt time.Time) error
+	// Release makes claimed messages ready to be published again.
+	Release(ctx context.Context, ids ...int64) error
+}
+
+//go:generate minimock -i Transactor -g -o transactor_mock.go
+
+// Transactor runs storage calls made with the ctx passed to fn in a single transaction.
+type Transactor interface {
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
@@ -3,14 +3,17 @@ package pg
 import (
 	"context"
 	"database/sql"
-	"fmt"
+	ierr "github.com/IakimenkoD/xm-companies-service/internal/errors"
+	"github.com/IakimenkoD/xm-companies-service/internal/metrics"
 	"github.com/IakimenkoD/xm-companies-service/internal/model"
 	"github.com/IakimenkoD/xm-companies-service/internal/repository/database"
 	"github.com/IakimenkoD/xm-companies-service/internal/repository/dataprovider"
+	"github.com/IakimenkoD/xm-companies-service/internal/tracing"
 	sq "github.com/Masterminds/squirrel"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -44,19 +47,42 @@ func (s *CompanyStore) GetByFilter(ctx context.Context, filter *dataprovider.Com
 }
 
 func (s *CompanyStore) GetListByFilter(ctx context.Context, filter *dataprovider.CompanyFilter) ([]*model.Company, error) {
+	ctx, end := s.start(ctx, "GetListByFilter")
+	defer end()
+
 	qb := sq.Select(
 		"companies.id",
 		"companies.name",
 		"companies.code",
 		"companies.country",
 		"companies.website",
-		"companies.phone",
+		"COALESCE(companies.phone, '') AS phone",
 		"companies.created_at",
 		"companies.updated_at",
+		"companies.deleted_at",
+		"companies.version",
 	).
 		From(s.schema + ".companies").
 		Where(getCompaniesCond(filter)).
-		OrderBy("companies.id")
+		OrderBy(getCompaniesOrder(filter)...)
+
+	if filter.Query != "" {
+		rank, args := getRelevance(filter)
+		qb = qb.Column(sq.Alias(sq.Expr(rank, args...), dataprovider.SortByRelevance)).
+			Where(getSearchCond(filter))
+	}
+
+	if filter.Cursor != nil {
+		cond, err := getCursorCond(filter)
+		if err != nil {
+			return nil, err
+		}
+		qb = qb.Where(cond)
+	}
+
+	if filter.Limit > 0 {
+		qb = qb.Limit(filter.Limit)
+	}
 
 	query, args, err := qb.PlaceholderFormat(sq.Dollar).ToSql()
 	if err != nil {
@@ -68,7 +94,7 @@ func (s *CompanyStore) GetListByFilter(ctx context.Context, filter *dataprovider
 		zap.Any("args", args))
 
 	companies := []*model.Company{}
-	if err = sqlx.SelectContext(ctx, s.db, &companies, query, args...); err != nil {
+	if err = sqlx.SelectContext(ctx, s.db.Conn(ctx), &companies, query, args...); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
@@ -79,6 +105,9 @@ func (s *CompanyStore) GetListByFilter(ctx context.Context, filter *dataprovider
 }
 
 func (s *CompanyStore) Insert(ctx context.Context, company *model.Company) (id int64, err error) {
+	ctx, end := s.start(ctx, "Insert")
+	defer end()
+
 	query, args, err := sq.Insert(s.schema + ".companies").
 		SetMap(map[string]interface{}{
 			"name":       company.Name,
@@ -98,8 +127,12 @@ func (s *CompanyStore) Insert(ctx context.Context, company *model.Company) (id i
 	s.log.Debug("inserting company query SQL",
 		zap.String("query", query),
 		zap.Any("args", args))
-	row := s.db.QueryRowxContext(ctx, query, args...)
-	if err = row.Err(); err != nil {
+	row := s.db.Conn(ctx).QueryRowxContext(ctx, query, args...)
+	err = row.Err()
+	switch {
+	case isUniqueViolation(err):
+		return id, ierr.CompanyExists
+	case err != nil:
 		return id, errors.Wrap(err, "can't execute SQL query for inserting company")
 	}
 
@@ -108,34 +141,100 @@ func (s *CompanyStore) Insert(ctx context.Context, company *model.Company) (id i
 	return id, errors.Wrap(err, "can't scan inserted company id")
 }
 
-func (s *CompanyStore) Update(ctx context.Context, company *model.Company) error {
-	updates := map[string]interface{}{
-		"updated_at": time.Now().UTC(),
+// insertBatchSize keeps amount of multi-row insert params below Postgres limit of 65535.
+const insertBatchSize = 1000
+
+func (s *CompanyStore) InsertBatch(ctx context.Context, companies []*model.Company) error {
+	ctx, end := s.start(ctx, "InsertBatch")
+	defer end()
+
+	for start := 0; start < len(companies); start += insertBatchSize {
+		end := start + insertBatchSize
+		if end > len(companies) {
+			end = len(companies)
+		}
+		if err := s.insertBatch(ctx, companies[start:end]); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	if !emptyString(company.Name) {
-		updates["name"] = company.Name
+func (s *CompanyStore) insertBatch(ctx context.Context, companies []*model.Company) error {
+	now := time.Now().UTC()
+	qb := sq.Insert(s.schema+".companies").
+		Columns("name", "code", "country", "website", "phone", "created_at")
+	byCode := make(map[string]*model.Company, len(companies))
+	for _, company := range companies {
+		qb = qb.Values(
+			company.Name,
+			company.Code,
+			strings.ToLower(company.Country),
+			strings.ToLower(company.Website),
+			company.Phone,
+			now,
+		)
+		byCode[company.Code] = company
 	}
 
-	if !emptyString(company.Code) {
-		updates["code"] = company.Code
+	query, args, err := qb.Suffix("RETURNING id, code").
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, "can't create query SQL for inserting companies")
 	}
 
-	if !emptyString(company.Country) {
-		updates["country"] = company.Country
+	s.log.Debug("inserting companies query SQL",
+		zap.String("query", query),
+		zap.Int("companies", len(companies)))
+
+	rows, err := s.db.Conn(ctx).QueryxContext(ctx, query, args...)
+	switch {
+	case isUniqueViolation(err):
+		return ierr.CompanyExists
+	case err != nil:
+		return errors.Wrap(err, "can't execute SQL query for inserting companies")
+	}
+	defer rows.Close()
+
+	// order of returned rows isn't guaranteed, codes of inserted companies are unique though
+	for rows.Next() {
+		var (
+			id   int64
+			code string
+		)
+		if err = rows.Scan(&id, &code); err != nil {
+			return errors.Wrap(err, "can't scan inserted company id")
+		}
+		if company, ok := byCode[code]; ok {
+			company.ID = id
+		}
 	}
 
-	if !emptyString(company.Website) {
-		updates["website"] = company.Website
+	if err = rows.Err(); isUniqueViolation(err) {
+		return ierr.CompanyExists
 	}
+	return errors.Wrap(err, "can't execute SQL query for inserting companies")
+}
+
+func (s *CompanyStore) Update(ctx context.Context, company *model.Company) error {
+	ctx, end := s.start(ctx, "Update")
+	defer end()
 
-	if !emptyString(company.Phone) {
-		updates["phone"] = company.Name
+	updates := map[string]interface{}{
+		"name":       company.Name,
+		"code":       company.Code,
+		"country":    strings.ToLower(company.Country),
+		"website":    strings.ToLower(company.Website),
+		"phone":      nullString(company.Phone),
+		"updated_at": time.Now().UTC(),
+		"version":    sq.Expr("version + 1"),
 	}
 
 	query, args, err := sq.Update(s.schema + ".companies").
 		SetMap(updates).
-		Where(sq.Eq{"id": company.ID}).
+		Where(sq.Eq{"id": company.ID, "deleted_at": nil}).
+		Where(versionCond(company.Version)).
 		PlaceholderFormat(sq.Dollar).
 		ToSql()
 	if err != nil {
@@ -146,14 +245,29 @@ func (s *CompanyStore) Update(ctx context.Context, company *model.Company) error
 		zap.String("query", query),
 		zap.Any("args", args))
 
-	_, err = s.db.ExecContext(ctx, query, args...)
+	res, err := s.db.Conn(ctx).ExecContext(ctx, query, args...)
+	switch {
+	case isUniqueViolation(err):
+		return ierr.CompanyExists
+	case err != nil:
+		return errors.Wrap(err, "can't execute SQL query for updating company")
+	}
 
-	return errors.Wrap(err, "can't execute SQL query for updating company")
+	return checkChanged(res, company.Version)
 }
 
-func (s *CompanyStore) DeleteByID(ctx context.Context, id int64) error {
-	query, args, err := sq.Delete(s.schema + ".companies").
-		Where(sq.Eq{"id": id}).
+func (s *CompanyStore) DeleteByID(ctx context.Context, id, version int64) error {
+	ctx, end := s.start(ctx, "DeleteByID")
+	defer end()
+
+	now := time.Now().UTC()
+	// updated_at is bumped as well so that deletion is seen by updated_since filter
+	query, args, err := sq.Update(s.schema+".companies").
+		Set("deleted_at", now).
+		Set("updated_at", now).
+		Set("version", sq.Expr("version + 1")).
+		Where(sq.Eq{"id": id, "deleted_at": nil}).
+		Where(versionCond(version)).
 		PlaceholderFormat(sq.Dollar).ToSql()
 	if err != nil {
 		return errors.Wrap(err, "creating sql query for deleting company")
@@ -163,15 +277,79 @@ func (s *CompanyStore) DeleteByID(ctx context.Context, id int64) error {
 		zap.String("query", query),
 		zap.Any("args", args))
 
-	_, err = s.db.ExecContext(ctx, query, args...)
+	res, err := s.db.Conn(ctx).ExecContext(ctx, query, args...)
+	if err != nil {
+		return errors.Wrap(err, "can't execute SQL query for deleting company")
+	}
+
+	return checkChanged(res, version)
+}
+
+func (s *CompanyStore) RestoreByID(ctx context.Context, id int64) error {
+	ctx, end := s.start(ctx, "RestoreByID")
+	defer end()
+
+	query, args, err := sq.Update(s.schema+".companies").
+		Set("deleted_at", nil).
+		Set("updated_at", time.Now().UTC()).
+		Set("version", sq.Expr("version + 1")).
+		Where(sq.Eq{"id": id}).
+		Where(sq.NotEq{"deleted_at": nil}).
+		PlaceholderFormat(sq.Dollar).ToSql()
+	if err != nil {
+		return errors.Wrap(err, "creating sql query for restoring company")
+	}
+
+	s.log.Debug("restoring company query SQL",
+		zap.String("query", query),
+		zap.Any("args", args))
+
+	res, err := s.db.Conn(ctx).ExecContext(ctx, query, args...)
+	switch {
+	case isUniqueViolation(err):
+		// another company took the code while this one was deleted
+		return ierr.CompanyExists
+	case err != nil:
+		return errors.Wrap(err, "can't execute SQL query for restoring company")
+	}
 
-	return err
+	// the company may be purged or restored by another request since it was read
+	return checkChanged(res, 0)
+}
+
+func (s *CompanyStore) PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	ctx, end := s.start(ctx, "PurgeDeleted")
+	defer end()
+
+	query, args, err := sq.Delete(s.schema + ".companies").
+		Where(sq.Lt{"deleted_at": deletedBefore.UTC()}).
+		PlaceholderFormat(sq.Dollar).ToSql()
+	if err != nil {
+		return 0, errors.Wrap(err, "creating sql query for purging deleted companies")
+	}
+
+	res, err := s.db.Conn(ctx).ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, "can't execute SQL query for purging deleted companies")
+	}
+
+	return res.RowsAffected()
+}
+
+// start starts span of the method, returned end finishes it and measures latency of the method.
+func (s *CompanyStore) start(ctx context.Context, method string) (context.Context, func()) {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "CompanyStore."+method)
+	return ctx, func() {
+		span.End()
+		metrics.DBQueryDuration.WithLabelValues("companies", method).Observe(time.Since(start).Seconds())
+	}
 }
 
 func getCompaniesCond(filter *dataprovider.CompanyFilter) sq.Sqlizer {
 	eq := make(sq.Eq)
 	neq := make(sq.NotEq)
-	var cond sq.Sqlizer = sq.And{eq, neq}
+	cond := sq.And{eq, neq}
 
 	if len(filter.IDs) > 0 {
 		eq["companies.id"] = filter.IDs
@@ -200,10 +378,171 @@ func getCompaniesCond(filter *dataprovider.CompanyFilter) sq.Sqlizer {
 	if len(filter.Phones) > 0 {
 		eq["companies.phone"] = filter.Phones
 	}
-	fmt.Println(cond)
+
+	if !filter.CreatedAfter.IsZero() {
+		cond = append(cond, sq.Gt{"companies.created_at": filter.CreatedAfter.UTC()})
+	}
+
+	if !filter.CreatedBefore.IsZero() {
+		cond = append(cond, sq.Lt{"companies.created_at": filter.CreatedBefore.UTC()})
+	}
+
+	switch {
+	case filter.OnlyDeleted:
+		cond = append(cond, sq.NotEq{"companies.deleted_at": nil})
+	case !filter.IncludeDeleted:
+		cond = append(cond, sq.Eq{"companies.deleted_at": nil})
+	}
+
+	if !filter.UpdatedSince.IsZero() {
+		// companies never updated have no updated_at
+		cond = append(cond, sq.GtOrEq{"COALESCE(companies.updated_at, companies.created_at)": filter.UpdatedSince.UTC()})
+	}
 	return cond
 }
 
+func getCompaniesOrder(filter *dataprovider.CompanyFilter) []string {
+	keys := dataprovider.SortKeys(filter.Sort)
+	order := make([]string, 0, len(keys))
+	for _, k := range keys {
+		column := "companies." + k.Field
+		if k.Field == dataprovider.SortByRelevance {
+			// selected by alias
+			column = k.Field
+		}
+
+		if k.Desc {
+			order = append(order, column+" DESC")
+			continue
+		}
+		order = append(order, column+" ASC")
+	}
+
+	return order
+}
+
+// getCursorCond builds keyset condition selecting rows placed after the cursor, e.g. for "name,-created_at":
+// (name > $1) OR (name = $1 AND created_at < $2) OR (name = $1 AND created_at = $2 AND id > $3)
+func getCursorCond(filter *dataprovider.CompanyFilter) (sq.Sqlizer, error) {
+	keys := dataprovider.SortKeys(filter.Sort)
+	values := make([]interface{}, 0, len(keys))
+	for n, k := range keys {
+		v, err := cursorValue(k.Field, filter.Cursor.Values[n])
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+
+	or := make(sq.Or, 0, len(keys))
+	for n, k := range keys {
+		and := make(sq.And, 0, n+1)
+		for i := 0; i < n; i++ {
+			and = append(and, getSortKeyCond(filter, keys[i].Field, "=", values[i]))
+		}
+		if k.Desc {
+			and = append(and, getSortKeyCond(filter, k.Field, "<", values[n]))
+		} else {
+			and = append(and, getSortKeyCond(filter, k.Field, ">", values[n]))
+		}
+		or = append(or, and)
+	}
+
+	return or, nil
+}
+
+func getSortKeyCond(filter *dataprovider.CompanyFilter, field, op string, value interface{}) sq.Sqlizer {
+	if field == dataprovider.SortByRelevance {
+		rank, args := getRelevance(filter)
+		return sq.Expr(rank+" "+op+" ?", append(args, value)...)
+	}
+	return sq.Expr("companies."+field+" "+op+" ?", value)
+}
+
+const tsQuery = "websearch_to_tsquery('simple', ?)"
+
+// getSearchCond returns condition matching filter.Query, full-text search is used by default.
+func getSearchCond(filter *dataprovider.CompanyFilter) sq.Sqlizer {
+	switch filter.Match {
+	case dataprovider.MatchPrefix:
+		return sq.Expr("companies.name ILIKE ?", escapeLike(filter.Query)+"%")
+	case dataprovider.MatchContains:
+		return sq.Expr("companies.name ILIKE ?", "%"+escapeLike(filter.Query)+"%")
+	case dataprovider.MatchFuzzy:
+		return sq.Expr("? <% companies.name", filter.Query)
+	default:
+		return sq.Expr("companies.search_vector @@ "+tsQuery, filter.Query)
+	}
+}
+
+// getRelevance returns expression ranking match of filter.Query, rank is rounded
+// to be compared with cursor value exactly.
+func getRelevance(filter *dataprovider.CompanyFilter) (string, []interface{}) {
+	switch filter.Match {
+	case dataprovider.MatchPrefix, dataprovider.MatchContains, dataprovider.MatchFuzzy:
+		return "ROUND(word_similarity(?, companies.name)::numeric, 6)", []interface{}{filter.Query}
+	default:
+		return "ROUND(ts_rank(companies.search_vector, " + tsQuery + ")::numeric, 6)", []interface{}{filter.Query}
+	}
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+func cursorValue(field, value string) (interface{}, error) {
+	switch field {
+	case dataprovider.SortByID:
+		id, err := strconv.ParseInt(value, 10, 64)
+		return id, errors.Wrap(err, "parsing cursor id")
+	case dataprovider.SortByCreatedAt:
+		t, err := time.Parse(time.RFC3339Nano, value)
+		return t, errors.Wrap(err, "parsing cursor created_at")
+	default:
+		return value, nil
+	}
+}
+
+// versionCond matches rows of given version, 0 version matches any.
+func versionCond(version int64) sq.Sqlizer {
+	if version == 0 {
+		return sq.And{}
+	}
+	return sq.Eq{"version": version}
+}
+
+// checkChanged tells why no row was changed by conditional update.
+func checkChanged(res sql.Result, version int64) error {
+	n, err := res.RowsAffected()
+	switch {
+	case err != nil:
+		return errors.Wrap(err, "getting amount of changed companies")
+	case n > 0:
+		return nil
+	case version > 0:
+		return ierr.VersionMismatch
+	default:
+		return ierr.CompanyNotFound
+	}
+}
+
+// uniqueViolation is SQLSTATE of unique constraint violation.
+const uniqueViolation = "23505"
+
+// isUniqueViolation tells whether err is caused by unique constraint, e.g. of company code.
+func isUniqueViolation(err error) bool {
+	var pgErr interface{ SQLState() string }
+	return errors.As(err, &pgErr) && pgErr.SQLState() == uniqueViolation
+}
+
+// nullString returns value stored as NULL for empty string.
+func nullString(s string) interface{} {
+	if emptyString(s) {
+		return nil
+	}
+	return s
+}
+
 func emptyString(s string) bool {
 	return len(strings.TrimSpace(s)) == 0
 }
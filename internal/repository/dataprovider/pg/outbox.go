@@ -0,0 +1,132 @@
+package pg
+
+import (
+	"context"
+	"github.com/IakimenkoD/xm-companies-service/internal/model"
+	"github.com/IakimenkoD/xm-companies-service/internal/repository/database"
+	"github.com/IakimenkoD/xm-companies-service/internal/repository/dataprovider"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"sort"
+	"time"
+)
+
+func NewOutboxStorage(client *database.Client, logger *zap.Logger) dataprovider.OutboxStorage {
+	return &OutboxStore{
+		db:     client,
+		schema: client.SchemaName,
+		log:    logger,
+	}
+}
+
+type OutboxStore struct {
+	db     *database.Client
+	schema string
+	log    *zap.Logger
+}
+
+func (s *OutboxStore) Insert(ctx context.Context, msg *model.OutboxMessage) error {
+	query, args, err := sq.Insert(s.schema + ".outbox").
+		SetMap(map[string]interface{}{
+			"event_type": msg.EventType,
+			"payload":    msg.Payload,
+			"headers":    msg.Headers,
+			"created_at": time.Now().UTC(),
+		}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, "can't create query SQL for inserting outbox message")
+	}
+
+	s.log.Debug("inserting outbox message query SQL",
+		zap.String("query", query),
+		zap.Any("args", args))
+
+	_, err = s.db.Conn(ctx).ExecContext(ctx, query, args...)
+
+	return errors.Wrap(err, "can't execute SQL query for inserting outbox message")
+}
+
+func (s *OutboxStore) ClaimPending(ctx context.Context, limit uint64, leaseUntil time.Time) ([]*model.OutboxMessage, error) {
+	pending, pendingArgs, err := sq.Select("id").
+		From(s.schema + ".outbox").
+		Where(sq.LtOrEq{"next_attempt_at": time.Now().UTC()}).
+		OrderBy("id").
+		Limit(limit).
+		Suffix("FOR UPDATE SKIP LOCKED").
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, "creating sql query for getting pending outbox messages")
+	}
+
+	query, args, err := sq.Update(s.schema+".outbox").
+		Set("next_attempt_at", leaseUntil.UTC()).
+		Where(sq.Expr("id IN ("+pending+")", pendingArgs...)).
+		Suffix("RETURNING id, event_type, payload, headers, attempts, created_at").
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, "creating sql query for claiming pending outbox messages")
+	}
+
+	messages := []*model.OutboxMessage{}
+	if err = sqlx.SelectContext(ctx, s.db.Conn(ctx), &messages, query, args...); err != nil {
+		return nil, errors.Wrapf(err, "claiming pending outbox messages with query %s", query)
+	}
+	// RETURNING doesn't keep order of the subquery
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+
+	return messages, nil
+}
+
+func (s *OutboxStore) DeleteByID(ctx context.Context, id int64) error {
+	query, args, err := sq.Delete(s.schema + ".outbox").
+		Where(sq.Eq{"id": id}).
+		PlaceholderFormat(sq.Dollar).ToSql()
+	if err != nil {
+		return errors.Wrap(err, "creating sql query for deleting outbox message")
+	}
+
+	_, err = s.db.Conn(ctx).ExecContext(ctx, query, args...)
+
+	return errors.Wrap(err, "can't execute SQL query for deleting outbox message")
+}
+
+func (s *OutboxStore) MarkFailed(ctx context.Context, id int64, reason string, nextAttemptAt time.Time) error {
+	query, args, err := sq.Update(s.schema+".outbox").
+		Set("attempts", sq.Expr("attempts + 1")).
+		Set("last_error", reason).
+		Set("next_attempt_at", nextAttemptAt.UTC()).
+		Where(sq.Eq{"id": id}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, "creating sql query for updating outbox message")
+	}
+
+	_, err = s.db.Conn(ctx).ExecContext(ctx, query, args...)
+
+	return errors.Wrap(err, "can't execute SQL query for updating outbox message")
+}
+
+func (s *OutboxStore) Release(ctx context.Context, ids ...int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	query, args, err := sq.Update(s.schema+".outbox").
+		Set("next_attempt_at", time.Now().UTC()).
+		Where(sq.Eq{"id": ids}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, "creating sql query for releasing outbox messages")
+	}
+
+	_, err = s.db.Conn(ctx).ExecContext(ctx, query, args...)
+
+	return errors.Wrap(err, "can't execute SQL query for releasing outbox messages")
+}
@@ -0,0 +1,139 @@
+package pg
+
+import (
+	"context"
+	"github.com/IakimenkoD/xm-companies-service/internal/model"
+	"github.com/IakimenkoD/xm-companies-service/internal/repository/database"
+	"github.com/IakimenkoD/xm-companies-service/internal/repository/dataprovider"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"time"
+)
+
+func NewRevisionStorage(client *database.Client, logger *zap.Logger) dataprovider.RevisionsStorage {
+	return &RevisionStore{
+		db:     client,
+		schema: client.SchemaName,
+		log:    logger,
+	}
+}
+
+type RevisionStore struct {
+	db     *database.Client
+	schema string
+	log    *zap.Logger
+}
+
+func (s *RevisionStore) Insert(ctx context.Context, revision *model.CompanyRevision) error {
+	query, args, err := sq.Insert(s.schema + ".company_revisions").
+		SetMap(map[string]interface{}{
+			"company_id": revision.CompanyID,
+			"action":     revision.Action,
+			"actor":      revision.Actor,
+			"client_ip":  revision.ClientIP,
+			"before":     jsonb(revision.Before),
+			"after":      jsonb(revision.After),
+			"created_at": time.Now().UTC(),
+		}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, "can't create query SQL for inserting company revision")
+	}
+
+	s.log.Debug("inserting company revision query SQL",
+		zap.String("query", query),
+		zap.Any("args", args))
+
+	_, err = s.db.Conn(ctx).ExecContext(ctx, query, args...)
+
+	return errors.Wrap(err, "can't execute SQL query for inserting company revision")
+}
+
+func (s *RevisionStore) InsertBatch(ctx context.Context, revisions []*model.CompanyRevision) error {
+	now := time.Now().UTC()
+	for start := 0; start < len(revisions); start += insertBatchSize {
+		end := start + insertBatchSize
+		if end > len(revisions) {
+			end = len(revisions)
+		}
+
+		qb := sq.Insert(s.schema+".company_revisions").
+			Columns("company_id", "action", "actor", "client_ip", "before", "after", "created_at")
+		for _, revision := range revisions[start:end] {
+			qb = qb.Values(
+				revision.CompanyID,
+				revision.Action,
+				revision.Actor,
+				revision.ClientIP,
+				jsonb(revision.Before),
+				jsonb(revision.After),
+				now,
+			)
+		}
+
+		query, args, err := qb.PlaceholderFormat(sq.Dollar).ToSql()
+		if err != nil {
+			return errors.Wrap(err, "can't create query SQL for inserting company revisions")
+		}
+
+		s.log.Debug("inserting company revisions query SQL",
+			zap.String("query", query),
+			zap.Int("revisions", end-start))
+
+		if _, err = s.db.Conn(ctx).ExecContext(ctx, query, args...); err != nil {
+			return errors.Wrap(err, "can't execute SQL query for inserting company revisions")
+		}
+	}
+
+	return nil
+}
+
+func (s *RevisionStore) GetListByFilter(ctx context.Context, filter *dataprovider.RevisionFilter) ([]*model.CompanyRevision, error) {
+	qb := sq.Select(
+		"company_revisions.id",
+		"company_revisions.company_id",
+		"company_revisions.action",
+		"company_revisions.actor",
+		"company_revisions.client_ip",
+		"company_revisions.before",
+		"company_revisions.after",
+		"company_revisions.created_at",
+	).
+		From(s.schema + ".company_revisions").
+		OrderBy("company_revisions.id DESC")
+
+	if len(filter.CompanyIDs) > 0 {
+		qb = qb.Where(sq.Eq{"company_revisions.company_id": filter.CompanyIDs})
+	}
+
+	if filter.BeforeID > 0 {
+		qb = qb.Where(sq.Lt{"company_revisions.id": filter.BeforeID})
+	}
+
+	if filter.Limit > 0 {
+		qb = qb.Limit(filter.Limit)
+	}
+
+	query, args, err := qb.PlaceholderFormat(sq.Dollar).ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, "creating sql query for getting company revisions by filter")
+	}
+
+	revisions := []*model.CompanyRevision{}
+	if err = sqlx.SelectContext(ctx, s.db.Conn(ctx), &revisions, query, args...); err != nil {
+		return nil, errors.Wrapf(err, "selecting company revisions with query %s", query)
+	}
+
+	return revisions, nil
+}
+
+// jsonb returns value stored as NULL for empty document.
+func jsonb(raw []byte) interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+	return []byte(raw)
+}
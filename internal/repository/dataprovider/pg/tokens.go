@@ -0,0 +1,151 @@
+package pg
+
+import (
+	"context"
+	"github.com/IakimenkoD/xm-companies-service/internal/model"
+	"github.com/IakimenkoD/xm-companies-service/internal/repository/database"
+	"github.com/IakimenkoD/xm-companies-service/internal/repository/dataprovider"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"time"
+)
+
+func NewTokenStorage(client *database.Client, logger *zap.Logger) dataprovider.TokensStorage {
+	return &TokenStore{
+		db:     client,
+		schema: client.SchemaName,
+		log:    logger,
+	}
+}
+
+type TokenStore struct {
+	db     *database.Client
+	schema string
+	log    *zap.Logger
+}
+
+func (s *TokenStore) GetRefreshToken(ctx context.Context, tokenHash string) (*model.RefreshToken, error) {
+	query, args, err := sq.Select(
+		"refresh_tokens.id",
+		"refresh_tokens.user_id",
+		"refresh_tokens.token_hash",
+		"refresh_tokens.expires_at",
+		"refresh_tokens.revoked_at",
+		"refresh_tokens.created_at",
+	).
+		From(s.schema + ".refresh_tokens").
+		Where(sq.Eq{"refresh_tokens.token_hash": tokenHash}).
+		Suffix("FOR UPDATE").
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, "creating sql query for getting refresh token")
+	}
+
+	tokens := []*model.RefreshToken{}
+	if err = sqlx.SelectContext(ctx, s.db.Conn(ctx), &tokens, query, args...); err != nil {
+		return nil, errors.Wrapf(err, "selecting refresh token with query %s", query)
+	}
+
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	return tokens[0], nil
+}
+
+func (s *TokenStore) InsertRefreshToken(ctx context.Context, token *model.RefreshToken) error {
+	query, args, err := sq.Insert(s.schema + ".refresh_tokens").
+		SetMap(map[string]interface{}{
+			"user_id":    token.UserID,
+			"token_hash": token.TokenHash,
+			"expires_at": token.ExpiresAt.UTC(),
+			"created_at": time.Now().UTC(),
+		}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, "can't create query SQL for inserting refresh token")
+	}
+
+	_, err = s.db.Conn(ctx).ExecContext(ctx, query, args...)
+
+	return errors.Wrap(err, "can't execute SQL query for inserting refresh token")
+}
+
+func (s *TokenStore) RevokeRefreshToken(ctx context.Context, id int64) error {
+	return s.revokeRefreshTokens(ctx, sq.Eq{"id": id})
+}
+
+func (s *TokenStore) RevokeUserRefreshTokens(ctx context.Context, userID int64) error {
+	return s.revokeRefreshTokens(ctx, sq.Eq{"user_id": userID})
+}
+
+func (s *TokenStore) revokeRefreshTokens(ctx context.Context, cond sq.Sqlizer) error {
+	query, args, err := sq.Update(s.schema+".refresh_tokens").
+		Set("revoked_at", time.Now().UTC()).
+		Where(cond).
+		Where(sq.Eq{"revoked_at": nil}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, "creating sql query for revoking refresh tokens")
+	}
+
+	s.log.Debug("revoking refresh tokens query SQL",
+		zap.String("query", query),
+		zap.Any("args", args))
+
+	_, err = s.db.Conn(ctx).ExecContext(ctx, query, args...)
+
+	return errors.Wrap(err, "can't execute SQL query for revoking refresh tokens")
+}
+
+// RevokeAccessToken adds token to revocation list, tokens expired by now are removed from it.
+func (s *TokenStore) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	cleanup, cleanupArgs, err := sq.Delete(s.schema + ".revoked_tokens").
+		Where(sq.Lt{"expires_at": time.Now().UTC()}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, "creating sql query for removing expired revoked tokens")
+	}
+
+	query, args, err := sq.Insert(s.schema+".revoked_tokens").
+		Columns("jti", "expires_at").
+		Values(jti, expiresAt.UTC()).
+		Suffix("ON CONFLICT (jti) DO NOTHING").
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, "creating sql query for revoking access token")
+	}
+
+	return s.db.WithTx(ctx, func(ctx context.Context) error {
+		if _, err := s.db.Conn(ctx).ExecContext(ctx, cleanup, cleanupArgs...); err != nil {
+			return errors.Wrap(err, "can't execute SQL query for removing expired revoked tokens")
+		}
+
+		_, err := s.db.Conn(ctx).ExecContext(ctx, query, args...)
+		return errors.Wrap(err, "can't execute SQL query for revoking access token")
+	})
+}
+
+func (s *TokenStore) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	query, args, err := sq.Select("count(*)").
+		From(s.schema + ".revoked_tokens").
+		Where(sq.Eq{"jti": jti}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return false, errors.Wrap(err, "creating sql query for checking revoked token")
+	}
+
+	var count int
+	if err = sqlx.GetContext(ctx, s.db.Conn(ctx), &count, query, args...); err != nil {
+		return false, errors.Wrap(err, "can't execute SQL query for checking revoked token")
+	}
+
+	return count > 0, nil
+}
@@ -0,0 +1,122 @@
+package pg
+
+import (
+	"context"
+	"github.com/IakimenkoD/xm-companies-service/internal/model"
+	"github.com/IakimenkoD/xm-companies-service/internal/repository/database"
+	"github.com/IakimenkoD/xm-companies-service/internal/repository/dataprovider"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"time"
+)
+
+func NewUserStorage(client *database.Client, logger *zap.Logger) dataprovider.UsersStorage {
+	return &UserStore{
+		db:     client,
+		schema: client.SchemaName,
+		log:    logger,
+	}
+}
+
+type UserStore struct {
+	db     *database.Client
+	schema string
+	log    *zap.Logger
+}
+
+func (s *UserStore) GetByFilter(ctx context.Context, filter *dataprovider.UserFilter) (*model.User, error) {
+	query, args, err := sq.Select(
+		"users.id",
+		"users.username",
+		"users.password_hash",
+		"users.role",
+		"users.disabled",
+		"users.created_at",
+		"users.updated_at",
+	).
+		From(s.schema + ".users").
+		Where(getUsersCond(filter)).
+		OrderBy("users.id").
+		Limit(1).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, "creating sql query for getting user by filter")
+	}
+
+	s.log.Debug("selecting user query SQL",
+		zap.String("query", query),
+		zap.Any("args", args))
+
+	users := []*model.User{}
+	if err = sqlx.SelectContext(ctx, s.db.Conn(ctx), &users, query, args...); err != nil {
+		return nil, errors.Wrapf(err, "selecting user by filter from database with query %s", query)
+	}
+
+	if len(users) == 0 {
+		return nil, nil
+	}
+	return users[0], nil
+}
+
+func (s *UserStore) Insert(ctx context.Context, user *model.User) (id int64, err error) {
+	query, args, err := sq.Insert(s.schema + ".users").
+		SetMap(map[string]interface{}{
+			"username":      user.Username,
+			"password_hash": user.PasswordHash,
+			"role":          user.Role,
+			"disabled":      user.Disabled,
+			"created_at":    time.Now().UTC(),
+		}).
+		Suffix("RETURNING id;").
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return id, errors.Wrap(err, "can't create query SQL for inserting user")
+	}
+
+	row := s.db.Conn(ctx).QueryRowxContext(ctx, query, args...)
+	if err = row.Err(); err != nil {
+		return id, errors.Wrap(err, "can't execute SQL query for inserting user")
+	}
+
+	err = row.Scan(&id)
+
+	return id, errors.Wrap(err, "can't scan inserted user id")
+}
+
+func (s *UserStore) Update(ctx context.Context, user *model.User) error {
+	query, args, err := sq.Update(s.schema + ".users").
+		SetMap(map[string]interface{}{
+			"password_hash": user.PasswordHash,
+			"role":          user.Role,
+			"disabled":      user.Disabled,
+			"updated_at":    time.Now().UTC(),
+		}).
+		Where(sq.Eq{"id": user.ID}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return errors.Wrap(err, "creating sql query for updating user")
+	}
+
+	_, err = s.db.Conn(ctx).ExecContext(ctx, query, args...)
+
+	return errors.Wrap(err, "can't execute SQL query for updating user")
+}
+
+func getUsersCond(filter *dataprovider.UserFilter) sq.Sqlizer {
+	eq := make(sq.Eq)
+
+	if len(filter.IDs) > 0 {
+		eq["users.id"] = filter.IDs
+	}
+
+	if len(filter.Usernames) > 0 {
+		eq["users.username"] = filter.Usernames
+	}
+
+	return eq
+}
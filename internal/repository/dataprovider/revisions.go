@@ -0,0 +1,66 @@
+package dataprovider
+
+import (
+	"context"
+	"github.com/IakimenkoD/xm-companies-service/internal/model"
+	"strconv"
+)
+
+//go:generate minimock -i RevisionsStorage -g -o revisions_storage_mock.go
+
+// RevisionsStorage is an append-only log of company changes.
+type RevisionsStorage interface {
+	Insert(ctx context.Context, revision *model.CompanyRevision) error
+	InsertBatch(ctx context.Context, revisions []*model.CompanyRevision) error
+	// GetListByFilter returns revisions, the latest first.
+	GetListByFilter(ctx context.Context, filter *RevisionFilter) ([]*model.CompanyRevision, error)
+}
+
+// RevisionFilter is a filter for company revisions in storage.
+type RevisionFilter struct {
+	CompanyIDs []int64
+	BeforeID   int64
+	Limit      uint64
+}
+
+func NewRevisionFilter() *RevisionFilter {
+	return &RevisionFilter{}
+}
+
+// ByCompanyIDs filters by xm.company_revisions.company_id
+func (f *RevisionFilter) ByCompanyIDs(ids ...int64) *RevisionFilter {
+	f.CompanyIDs = ids
+	return f
+}
+
+// Before returns revisions older than the one with given id.
+func (f *RevisionFilter) Before(id int64) *RevisionFilter {
+	f.BeforeID = id
+	return f
+}
+
+// WithLimit limits amount of revisions returned, 0 means no limit.
+func (f *RevisionFilter) WithLimit(limit uint64) *RevisionFilter {
+	f.Limit = limit
+	return f
+}
+
+var revisionsSort = []SortField{{Field: SortByID, Desc: true}}
+
+// NewRevisionCursor creates cursor pointing to the revision.
+func NewRevisionCursor(last *model.CompanyRevision) *Cursor {
+	return &Cursor{
+		Sort:   sortString(revisionsSort),
+		Values: []string{strconv.FormatInt(last.ID, 10)},
+	}
+}
+
+// DecodeRevisionCursor parses token created by NewRevisionCursor, it returns id of the revision cursor points to.
+func DecodeRevisionCursor(token string) (int64, error) {
+	c, err := DecodeCursor(token, revisionsSort)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(c.Values[0], 10, 64)
+}
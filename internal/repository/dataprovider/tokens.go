@@ -0,0 +1,19 @@
+package dataprovider
+
+import (
+	"context"
+	"github.com/IakimenkoD/xm-companies-service/internal/model"
+	"time"
+)
+
+//go:generate minimock -i TokensStorage -g -o tokens_storage_mock.go
+type TokensStorage interface {
+	// GetRefreshToken locks refresh token by hash, must be called in a transaction.
+	GetRefreshToken(ctx context.Context, tokenHash string) (*model.RefreshToken, error)
+	InsertRefreshToken(ctx context.Context, token *model.RefreshToken) error
+	RevokeRefreshToken(ctx context.Context, id int64) error
+	RevokeUserRefreshTokens(ctx context.Context, userID int64) error
+
+	RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error)
+}
@@ -0,0 +1,36 @@
+package dataprovider
+
+import (
+	"context"
+	"github.com/IakimenkoD/xm-companies-service/internal/model"
+)
+
+//go:generate minimock -i UsersStorage -g -o users_storage_mock.go
+type UsersStorage interface {
+	GetByFilter(ctx context.Context, filter *UserFilter) (*model.User, error)
+
+	Insert(ctx context.Context, user *model.User) (int64, error)
+	Update(ctx context.Context, user *model.User) error
+}
+
+// UserFilter is a filter for users in storage.
+type UserFilter struct {
+	IDs       []int64
+	Usernames []string
+}
+
+func NewUserFilter() *UserFilter {
+	return &UserFilter{}
+}
+
+// ByIDs filters by xm.users.id
+func (f *UserFilter) ByIDs(ids ...int64) *UserFilter {
+	f.IDs = ids
+	return f
+}
+
+// ByUsernames filters by xm.users.username
+func (f *UserFilter) ByUsernames(usernames ...string) *UserFilter {
+	f.Usernames = usernames
+	return f
+}
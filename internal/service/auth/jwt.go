@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"context"
+	ierr "github.com/IakimenkoD/xm-companies-service/internal/errors"
+	"github.com/IakimenkoD/xm-companies-service/internal/model"
+	"github.com/IakimenkoD/xm-companies-service/internal/service"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+)
+
+// RevocationList tells whether access token was revoked before expiration.
+type RevocationList interface {
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+func NewJWTAuthenticator(keys *KeySet, revocations RevocationList) service.Authenticator {
+	return &JWTAuthenticator{
+		keys:        keys,
+		revocations: revocations,
+	}
+}
+
+// JWTAuthenticator accepts not revoked tokens signed with one of the keys.
+type JWTAuthenticator struct {
+	keys        *KeySet
+	revocations RevocationList
+}
+
+func (a *JWTAuthenticator) Authenticate(ctx context.Context, token string) (*model.Claims, error) {
+	claims := &model.Claims{}
+	tkn, err := jwt.ParseWithClaims(token, claims, a.keys.VerificationKey)
+	if err != nil {
+		var vErr *jwt.ValidationError
+		if errors.As(err, &vErr) && vErr.Errors&jwt.ValidationErrorMalformed != 0 {
+			return nil, errors.Wrap(ierr.WrongRequest, "malformed token")
+		}
+		return nil, ierr.InvalidToken
+	}
+	// missing exp is treated as valid by the parser, access tokens never live forever though
+	if !tkn.Valid || claims.Id == "" || claims.ExpiresAt == 0 {
+		return nil, ierr.InvalidToken
+	}
+
+	revoked, err := a.revocations.IsRevoked(ctx, claims.Id)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ierr.InvalidToken
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"github.com/IakimenkoD/xm-companies-service/internal/config"
+	ierr "github.com/IakimenkoD/xm-companies-service/internal/errors"
+	"github.com/IakimenkoD/xm-companies-service/internal/model"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+// revocations is RevocationList of revoked token ids.
+type revocations map[string]bool
+
+func (r revocations) IsRevoked(_ context.Context, jti string) (bool, error) {
+	return r[jti], nil
+}
+
+func TestJWTAuthenticator_Authenticate(t *testing.T) {
+	conf, err := config.New("", zap.NewNop())
+	require.NoError(t, err)
+	conf.API.JWTAlgorithm = "HS256"
+	conf.API.JWTKey = "secret of unit tests, 32+ bytes long"
+
+	keys, err := NewKeySet(conf, zap.NewNop())
+	require.NoError(t, err)
+	authenticator := NewJWTAuthenticator(keys, revocations{"revoked": true})
+
+	expiresAt := time.Now().Add(time.Minute).Unix()
+	tt := []struct {
+		name   string
+		claims jwt.StandardClaims
+		err    error
+	}{
+		{
+			name:   "valid token",
+			claims: jwt.StandardClaims{Id: "valid", ExpiresAt: expiresAt},
+		},
+		{
+			name:   "fail: expired",
+			claims: jwt.StandardClaims{Id: "expired", ExpiresAt: time.Now().Add(-time.Minute).Unix()},
+			err:    ierr.InvalidToken,
+		},
+		{
+			name:   "fail: expiration missing",
+			claims: jwt.StandardClaims{Id: "endless"},
+			err:    ierr.InvalidToken,
+		},
+		{
+			name:   "fail: id missing",
+			claims: jwt.StandardClaims{ExpiresAt: expiresAt},
+			err:    ierr.InvalidToken,
+		},
+		{
+			name:   "fail: revoked",
+			claims: jwt.StandardClaims{Id: "revoked", ExpiresAt: expiresAt},
+			err:    ierr.InvalidToken,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			token, err := keys.Sign(&model.Claims{Username: "user", StandardClaims: tc.claims})
+			require.NoError(t, err)
+
+			claims, err := authenticator.Authenticate(context.Background(), token)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "user", claims.Username)
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"github.com/IakimenkoD/xm-companies-service/internal/config"
+	ierr "github.com/IakimenkoD/xm-companies-service/internal/errors"
+	"github.com/IakimenkoD/xm-companies-service/internal/model"
+	"github.com/IakimenkoD/xm-companies-service/internal/service"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minSecretLength is a minimal length of HS256 secret, shorter ones can be brute forced.
+const minSecretLength = 32
+
+// NewKeySet creates signing keys holder, for RS256 and EdDSA keys are loaded from
+// PEM files of API.JWTKeysDir, file name without extension is used as kid.
+func NewKeySet(conf *config.Config, log *zap.Logger) (*KeySet, error) {
+	k := &KeySet{
+		method:          jwt.GetSigningMethod(conf.API.JWTAlgorithm),
+		secret:          []byte(conf.API.JWTKey),
+		dir:             conf.API.JWTKeysDir,
+		interval:        conf.API.JWTKeysReloadInterval,
+		activationDelay: conf.API.JWTKeysActivationDelay,
+		log:             log,
+	}
+
+	switch k.method {
+	case jwt.SigningMethodHS256:
+		if len(k.secret) < minSecretLength {
+			return nil, errors.Errorf("jwt key has to be at least %d bytes long", minSecretLength)
+		}
+		return k, nil
+	case jwt.SigningMethodRS256, jwt.SigningMethodEdDSA:
+		return k, k.Reload()
+	default:
+		return nil, errors.Errorf("unsupported jwt algorithm %q", conf.API.JWTAlgorithm)
+	}
+}
+
+// KeySet signs tokens with the active key having the greatest kid, so naming key files by date
+// rotates keys once a new file is added. A key is published by JWKS as soon as it's loaded, but
+// becomes active activationDelay after its file was modified, so verifiers caching JWKS know it
+// before the first token is signed. All the loaded keys are accepted for verification.
+type KeySet struct {
+	method          jwt.SigningMethod
+	secret          []byte
+	dir             string
+	interval        time.Duration
+	activationDelay time.Duration
+	log             *zap.Logger
+
+	mu         sync.RWMutex
+	keys       map[string]crypto.Signer
+	currentKID string
+}
+
+// Reload reads keys directory, the previous keys stay in use if it fails.
+func (k *KeySet) Reload() error {
+	paths, err := filepath.Glob(filepath.Join(k.dir, "*.pem"))
+	if err != nil {
+		return errors.Wrap(err, "listing jwt keys")
+	}
+	if len(paths) == 0 {
+		return errors.Errorf("no jwt keys found in %q", k.dir)
+	}
+
+	now := time.Now()
+	keys := make(map[string]crypto.Signer, len(paths))
+	activeAt := make(map[string]time.Time, len(paths))
+	kids := make([]string, 0, len(paths))
+	for _, p := range paths {
+		key, modified, err := k.readKey(p)
+		if err != nil {
+			return err
+		}
+		kid := strings.TrimSuffix(filepath.Base(p), filepath.Ext(p))
+		keys[kid] = key
+		activeAt[kid] = modified.Add(k.activationDelay)
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	current := ""
+	for i := len(kids) - 1; i >= 0; i-- {
+		if !activeAt[kids[i]].After(now) {
+			current = kids[i]
+			break
+		}
+	}
+	if current == "" {
+		// none of keys is active yet, the current one is kept if it's still there,
+		// otherwise there are no verifiers knowing older keys, e.g. on the first start
+		current = kids[len(kids)-1]
+		if _, ok := keys[k.currentKID]; ok {
+			current = k.currentKID
+		}
+	}
+
+	for _, kid := range kids {
+		if _, ok := k.keys[kid]; !ok && kid != current {
+			k.log.Info("jwt key published", zap.String("kid", kid), zap.Time("active_at", activeAt[kid]))
+		}
+	}
+	if current != k.currentKID {
+		k.log.Info("jwt signing key rotated", zap.String("kid", current), zap.String("previous_kid", k.currentKID))
+		k.currentKID = current
+	}
+	k.keys = keys
+
+	return nil
+}
+
+// Run reloads keys every API.JWTKeysReloadInterval until ctx is cancelled.
+func (k *KeySet) Run(ctx context.Context) {
+	if k.method == jwt.SigningMethodHS256 || k.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(k.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := k.Reload(); err != nil {
+				k.log.Error("reloading jwt keys", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (k *KeySet) Sign(claims *model.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.method, claims)
+	if k.method == jwt.SigningMethodHS256 {
+		return token.SignedString(k.secret)
+	}
+
+	k.mu.RLock()
+	kid, key := k.currentKID, k.keys[k.currentKID]
+	k.mu.RUnlock()
+
+	token.Header["kid"] = kid
+	return token.SignedString(key)
+}
+
+// VerificationKey is a jwt.Keyfunc returning key the token was signed with.
+func (k *KeySet) VerificationKey(token *jwt.Token) (interface{}, error) {
+	if token.Method.Alg() != k.method.Alg() {
+		return nil, errors.Errorf("unexpected signing method %s", token.Header["alg"])
+	}
+	if k.method == jwt.SigningMethodHS256 {
+		return k.secret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+
+	k.mu.RLock()
+	key, ok := k.keys[kid]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, errors.Wrapf(ierr.InvalidToken, "unknown kid %q", kid)
+	}
+
+	return key.Public(), nil
+}
+
+// JWKS returns public keys, symmetric HS256 key is never published.
+func (k *KeySet) JWKS() service.JSONWebKeySet {
+	set := service.JSONWebKeySet{Keys: []service.JSONWebKey{}}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	for kid, key := range k.keys {
+		jwk := service.JSONWebKey{
+			KID: kid,
+			Use: "sig",
+			Alg: k.method.Alg(),
+		}
+
+		switch pub := key.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].KID < set.Keys[j].KID })
+
+	return set
+}
+
+// readKey returns private key of the file and time the file was modified.
+func (k *KeySet) readKey(path string) (crypto.Signer, time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, time.Time{}, errors.Wrapf(err, "reading jwt key %s", path)
+	}
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, errors.Wrapf(err, "reading jwt key %s", path)
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, time.Time{}, errors.Errorf("no PEM data in jwt key %s", path)
+	}
+
+	var key interface{}
+	if block.Type == "RSA PRIVATE KEY" {
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, time.Time{}, errors.Wrapf(err, "parsing jwt key %s", path)
+	}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		if k.method == jwt.SigningMethodRS256 {
+			return key, info.ModTime(), nil
+		}
+	case ed25519.PrivateKey:
+		if k.method == jwt.SigningMethodEdDSA {
+			return key, info.ModTime(), nil
+		}
+	}
+
+	return nil, time.Time{}, errors.Errorf("jwt key %s doesn't match %s algorithm", path, k.method.Alg())
+}
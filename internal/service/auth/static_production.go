@@ -0,0 +1,30 @@
+//go:build production
+// +build production
+
+package auth
+
+import (
+	"context"
+	ierr "github.com/IakimenkoD/xm-companies-service/internal/errors"
+	"github.com/IakimenkoD/xm-companies-service/internal/model"
+	"github.com/IakimenkoD/xm-companies-service/internal/service"
+)
+
+// StaticAuthCompiled tells whether static tokens are accepted, builds tagged production exclude them.
+const StaticAuthCompiled = false
+
+// NewStaticAuthenticator accepts no static tokens in production builds, all tokens are passed to next.
+func NewStaticAuthenticator(_ map[string]string, next service.Authenticator) service.Authenticator {
+	return &StaticAuthenticator{next: next}
+}
+
+type StaticAuthenticator struct {
+	next service.Authenticator
+}
+
+func (a *StaticAuthenticator) Authenticate(ctx context.Context, token string) (*model.Claims, error) {
+	if a.next != nil {
+		return a.next.Authenticate(ctx, token)
+	}
+	return nil, ierr.InvalidToken
+}
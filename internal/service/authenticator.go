@@ -0,0 +1,41 @@
+package service
+
+import (
+	"context"
+	"github.com/IakimenkoD/xm-companies-service/internal/model"
+)
+
+//go:generate minimock -i Authenticator -g -o authenticator_mock.go
+
+// Authenticator validates access tokens and returns claims of their owners.
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (*model.Claims, error)
+}
+
+//go:generate minimock -i TokenSigner -g -o token_signer_mock.go
+
+// TokenSigner signs access tokens and publishes public keys to verify them.
+type TokenSigner interface {
+	Sign(claims *model.Claims) (string, error)
+	JWKS() JSONWebKeySet
+}
+
+// JSONWebKeySet is a set of public keys as described by RFC 7517.
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	KID string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+
+	// RSA public key
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// Ed25519 public key
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
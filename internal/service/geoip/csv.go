@@ -0,0 +1,90 @@
+package geoip
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	ierr "github.com/IakimenkoD/xm-companies-service/internal/errors"
+	"github.com/IakimenkoD/xm-companies-service/internal/service"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+type cidrEntry struct {
+	network *net.IPNet
+	start   net.IP
+	country string
+}
+
+// NewCSVIpChecker loads "cidr,country" rows, e.g. "1.0.0.0/24,AU", networks must not overlap.
+// Rows starting with # and the header row are skipped.
+func NewCSVIpChecker(path string) (service.IpChecker, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening geoip csv %s", path)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = 2
+
+	var entries []cidrEntry
+	for line := 1; ; line++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading geoip csv %s", path)
+		}
+
+		_, network, err := net.ParseCIDR(strings.TrimSpace(row[0]))
+		if err != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, errors.Wrapf(err, "parsing geoip csv %s line %d", path, line)
+		}
+
+		entries = append(entries, cidrEntry{
+			network: network,
+			start:   network.IP.To16(),
+			country: strings.ToUpper(strings.TrimSpace(row[1])),
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].start, entries[j].start) < 0
+	})
+
+	return &CSV{entries: entries}, nil
+}
+
+// CSV resolves location using CIDR to country table loaded in memory.
+type CSV struct {
+	entries []cidrEntry
+}
+
+// GetUserLocation gets location of the network containing ip.
+func (c *CSV) GetUserLocation(_ context.Context, ip string) (location string, err error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return "", errors.Errorf("invalid ip address %q", ip)
+	}
+	addr = addr.To16()
+
+	// the last network starting not after addr is the only candidate
+	n := sort.Search(len(c.entries), func(i int) bool {
+		return bytes.Compare(c.entries[i].start, addr) > 0
+	})
+	if n == 0 || !c.entries[n-1].network.Contains(addr) {
+		return "", ierr.UnknownLocation
+	}
+
+	return c.entries[n-1].country, nil
+}
@@ -0,0 +1,110 @@
+package geoip
+
+import (
+	"context"
+	ierr "github.com/IakimenkoD/xm-companies-service/internal/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func writeCSV(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "ip2country.csv")
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestNewCSVIpChecker(t *testing.T) {
+	tt := []struct {
+		name    string
+		content string
+		entries int
+		wantErr bool
+	}{
+		{
+			name:    "header and comments are skipped",
+			content: "cidr,country\n# comment\n1.0.0.0/24,AU\n2001:db8::/32, gb \n",
+			entries: 2,
+		},
+		{
+			name:    "without header",
+			content: "1.0.0.0/24,AU\n",
+			entries: 1,
+		},
+		{
+			name:    "empty file",
+			content: "",
+		},
+		{
+			name:    "fail: invalid cidr",
+			content: "cidr,country\n1.0.0.0/24,AU\n1.0.0.300/24,AU\n",
+			wantErr: true,
+		},
+		{
+			name:    "fail: wrong number of fields",
+			content: "1.0.0.0/24,AU,extra\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			checker, err := NewCSVIpChecker(writeCSV(t, tc.content))
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, checker.(*CSV).entries, tc.entries)
+		})
+	}
+}
+
+func TestNewCSVIpChecker_MissingFile(t *testing.T) {
+	_, err := NewCSVIpChecker(filepath.Join(t.TempDir(), "missing.csv"))
+	assert.Error(t, err)
+}
+
+func TestCSV_GetUserLocation(t *testing.T) {
+	checker, err := NewCSVIpChecker(writeCSV(t, `cidr,country
+10.0.0.0/8,us
+1.0.0.0/24,AU
+1.0.2.0/23,CN
+2001:db8::/32,GB
+`))
+	require.NoError(t, err)
+
+	tt := []struct {
+		name     string
+		ip       string
+		location string
+		err      error
+	}{
+		{name: "first address of network", ip: "1.0.0.0", location: "AU"},
+		{name: "last address of network", ip: "1.0.0.255", location: "AU"},
+		{name: "country is uppercased", ip: "10.20.30.40", location: "US"},
+		{name: "network declared out of order", ip: "1.0.3.1", location: "CN"},
+		{name: "ipv6", ip: "2001:db8::1", location: "GB"},
+		{name: "fail: gap between networks", ip: "1.0.1.1", err: ierr.UnknownLocation},
+		{name: "fail: before the first network", ip: "0.0.0.1", err: ierr.UnknownLocation},
+		{name: "fail: after the last network", ip: "2001:db9::1", err: ierr.UnknownLocation},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			location, err := checker.GetUserLocation(context.Background(), tc.ip)
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.location, location)
+		})
+	}
+
+	t.Run("fail: invalid ip", func(t *testing.T) {
+		_, err := checker.GetUserLocation(context.Background(), "1.0.0")
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ierr.UnknownLocation)
+	})
+}
@@ -0,0 +1,49 @@
+package geoip
+
+import (
+	"context"
+	ierr "github.com/IakimenkoD/xm-companies-service/internal/errors"
+	"github.com/IakimenkoD/xm-companies-service/internal/service"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/pkg/errors"
+	"net"
+)
+
+// NewMMDBIpChecker opens MaxMind DB file, e.g. GeoLite2-Country.mmdb or GeoLite2-City.mmdb.
+func NewMMDBIpChecker(path string) (service.IpChecker, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening mmdb %s", path)
+	}
+
+	return &MMDB{reader: reader}, nil
+}
+
+// MMDB resolves location using local MaxMind DB.
+type MMDB struct {
+	reader *maxminddb.Reader
+}
+
+type mmdbRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+// GetUserLocation gets location from MaxMind DB by ip.
+func (m *MMDB) GetUserLocation(_ context.Context, ip string) (location string, err error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return "", errors.Errorf("invalid ip address %q", ip)
+	}
+
+	var record mmdbRecord
+	if err = m.reader.Lookup(addr, &record); err != nil {
+		return "", errors.Wrap(err, "looking up mmdb")
+	}
+
+	if record.Country.ISOCode == "" {
+		return "", ierr.UnknownLocation
+	}
+	return record.Country.ISOCode, nil
+}
@@ -5,7 +5,9 @@ import (
 	"github.com/IakimenkoD/xm-companies-service/internal/config"
 	ierr "github.com/IakimenkoD/xm-companies-service/internal/errors"
 	"github.com/IakimenkoD/xm-companies-service/internal/service"
+	"github.com/IakimenkoD/xm-companies-service/internal/tracing"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"io/ioutil"
 	"net/http"
@@ -22,7 +24,7 @@ func NewIpChecker(conf *config.Config, log *zap.Logger) service.IpChecker {
 			Timeout: conf.IpApi.Timeout,
 		},
 		Url:   conf.IpApi.Address,
-		debug: conf.Environment == "development",
+		debug: conf.Environment == config.EnvironmentDevelopment,
 		log:   log,
 	}
 }
@@ -37,6 +39,15 @@ type IpApi struct {
 
 // GetUserLocation gets location from IpApi service by ip.
 func (i *IpApi) GetUserLocation(ctx context.Context, ip string) (location string, err error) {
+	ctx, span := tracing.Start(ctx, "IpApi.GetUserLocation", trace.WithSpanKind(trace.SpanKindClient))
+	defer func() {
+		spanErr := err
+		// unknown ip is an expected answer rather than failure
+		if errors.Is(spanErr, ierr.UnknownLocation) {
+			spanErr = nil
+		}
+		tracing.End(span, spanErr)
+	}()
 
 	if i.debug {
 		if ip == localhost {
@@ -1,6 +1,13 @@
 package service
 
-import "context"
+import (
+	"context"
+	ierr "github.com/IakimenkoD/xm-companies-service/internal/errors"
+	"github.com/IakimenkoD/xm-companies-service/internal/metrics"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"time"
+)
 
 //go:generate minimock -i IpChecker -g -o ip_checker_mock.go
 
@@ -8,3 +15,62 @@ import "context"
 type IpChecker interface {
 	GetUserLocation(ctx context.Context, ip string) (location string, err error)
 }
+
+// NewIpCheckerChain asks providers in order until one of them resolves the location.
+func NewIpCheckerChain(log *zap.Logger, providers ...IpChecker) IpChecker {
+	return &IpCheckerChain{
+		providers: providers,
+		log:       log,
+	}
+}
+
+type IpCheckerChain struct {
+	providers []IpChecker
+	log       *zap.Logger
+}
+
+// GetUserLocation returns the last provider failure other than UnknownLocation, or UnknownLocation
+// if none of providers knows the ip.
+func (c *IpCheckerChain) GetUserLocation(ctx context.Context, ip string) (location string, err error) {
+	err = ierr.UnknownLocation
+	for n, p := range c.providers {
+		location, pErr := p.GetUserLocation(ctx, ip)
+		if pErr == nil {
+			return location, nil
+		}
+
+		c.log.Debug("location provider failed, trying next one",
+			zap.Int("provider", n),
+			zap.String("ip", ip),
+			zap.Error(pErr))
+		if !errors.Is(pErr, ierr.UnknownLocation) {
+			err = pErr
+		}
+	}
+
+	return "", err
+}
+
+// NewMeasuredIpChecker measures latency and failures of named provider.
+func NewMeasuredIpChecker(name string, next IpChecker) IpChecker {
+	return &MeasuredIpChecker{
+		name: name,
+		next: next,
+	}
+}
+
+type MeasuredIpChecker struct {
+	name string
+	next IpChecker
+}
+
+func (c *MeasuredIpChecker) GetUserLocation(ctx context.Context, ip string) (string, error) {
+	start := time.Now()
+	location, err := c.next.GetUserLocation(ctx, ip)
+	metrics.LocationLookupDuration.WithLabelValues(c.name).Observe(time.Since(start).Seconds())
+	if err != nil && !errors.Is(err, ierr.UnknownLocation) {
+		metrics.LocationLookupErrors.WithLabelValues(c.name).Inc()
+	}
+
+	return location, err
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"container/list"
+	"context"
+	ierr "github.com/IakimenkoD/xm-companies-service/internal/errors"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// NewCachedIpChecker memoizes locations resolved by next in LRU cache of given size,
+// UnknownLocation is cached for negativeTTL, other errors are never cached.
+func NewCachedIpChecker(next IpChecker, size int, ttl, negativeTTL time.Duration) *CachedIpChecker {
+	return &CachedIpChecker{
+		next:        next,
+		size:        size,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		items:       make(map[string]*list.Element, size),
+		order:       list.New(),
+		now:         time.Now,
+	}
+}
+
+// CachedIpChecker is an IpChecker decorator, concurrent lookups of the same ip are made once.
+type CachedIpChecker struct {
+	next        IpChecker
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	mu    sync.Mutex
+	items map[string]*list.Element
+	order *list.List // front is the most recently used
+
+	group singleflight.Group
+	stats IpCheckerCacheStats
+	// now is replaced by tests to expire items
+	now func() time.Time
+}
+
+// IpCheckerCacheStats are counters of cache lookups since start.
+type IpCheckerCacheStats struct {
+	Hits         uint64
+	NegativeHits uint64
+	Misses       uint64
+}
+
+type cacheItem struct {
+	ip        string
+	location  string
+	unknown   bool
+	expiresAt time.Time
+}
+
+func (c *CachedIpChecker) GetUserLocation(ctx context.Context, ip string) (location string, err error) {
+	if item, ok := c.get(ip); ok {
+		if item.unknown {
+			atomic.AddUint64(&c.stats.NegativeHits, 1)
+			return "", ierr.UnknownLocation
+		}
+		atomic.AddUint64(&c.stats.Hits, 1)
+		return item.location, nil
+	}
+	atomic.AddUint64(&c.stats.Misses, 1)
+
+	// lookup is shared by concurrent callers, so it must not be cancelled by the first of them
+	ch := c.group.DoChan(ip, func() (interface{}, error) {
+		location, err := c.next.GetUserLocation(detachedContext{ctx}, ip)
+		switch {
+		case err == nil:
+			c.put(&cacheItem{ip: ip, location: location, expiresAt: c.now().Add(c.ttl)})
+		case errors.Is(err, ierr.UnknownLocation):
+			c.put(&cacheItem{ip: ip, unknown: true, expiresAt: c.now().Add(c.negativeTTL)})
+		}
+		return location, err
+	})
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return "", res.Err
+		}
+		return res.Val.(string), nil
+	}
+}
+
+// Stats returns cache hit and miss counters.
+func (c *CachedIpChecker) Stats() IpCheckerCacheStats {
+	return IpCheckerCacheStats{
+		Hits:         atomic.LoadUint64(&c.stats.Hits),
+		NegativeHits: atomic.LoadUint64(&c.stats.NegativeHits),
+		Misses:       atomic.LoadUint64(&c.stats.Misses),
+	}
+}
+
+func (c *CachedIpChecker) get(ip string) (*cacheItem, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[ip]
+	if !ok {
+		return nil, false
+	}
+
+	item := el.Value.(*cacheItem)
+	if c.now().After(item.expiresAt) {
+		c.order.Remove(el)
+		delete(c.items, ip)
+		return nil, false
+	}
+
+	c.order.MoveToFront(el)
+	return item, true
+}
+
+func (c *CachedIpChecker) put(item *cacheItem) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[item.ip]; ok {
+		el.Value = item
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.items[item.ip] = c.order.PushFront(item)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheItem).ip)
+	}
+}
+
+// detachedContext keeps values of the parent context, but is never cancelled.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (deadline time.Time, ok bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}                   { return nil }
+func (detachedContext) Err() error                              { return nil }
+func (c detachedContext) Value(key interface{}) interface{}     { return c.parent.Value(key) }
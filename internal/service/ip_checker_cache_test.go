@@ -0,0 +1,200 @@
+package service
+
+import (
+	"context"
+	ierr "github.com/IakimenkoD/xm-companies-service/internal/errors"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// stubLocations resolves ips of locations, others are unknown, and counts lookups by ip.
+type stubLocations struct {
+	locations map[string]string
+	mu        sync.Mutex
+	lookups   map[string]int
+}
+
+func (s *stubLocations) GetUserLocation(_ context.Context, ip string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lookups == nil {
+		s.lookups = map[string]int{}
+	}
+	s.lookups[ip]++
+
+	location, ok := s.locations[ip]
+	if !ok {
+		return "", ierr.UnknownLocation
+	}
+	return location, nil
+}
+
+func (s *stubLocations) Lookups(ip string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lookups[ip]
+}
+
+func newTestCache(next IpChecker, size int) (*CachedIpChecker, *testClock) {
+	clock := &testClock{now: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)}
+	c := NewCachedIpChecker(next, size, time.Hour, time.Minute*5)
+	c.now = clock.Now
+	return c, clock
+}
+
+func lookup(t *testing.T, c IpChecker, ip string) string {
+	location, err := c.GetUserLocation(context.Background(), ip)
+	require.NoError(t, err)
+	return location
+}
+
+func TestCachedIpChecker_LRU(t *testing.T) {
+	next := &stubLocations{locations: map[string]string{"1.1.1.1": "CY", "2.2.2.2": "GR", "3.3.3.3": "US"}}
+	c, _ := newTestCache(next, 2)
+
+	assert.Equal(t, "CY", lookup(t, c, "1.1.1.1"))
+	assert.Equal(t, "GR", lookup(t, c, "2.2.2.2"))
+	// 1.1.1.1 becomes the most recently used, so 2.2.2.2 is evicted by 3.3.3.3
+	assert.Equal(t, "CY", lookup(t, c, "1.1.1.1"))
+	assert.Equal(t, "US", lookup(t, c, "3.3.3.3"))
+
+	assert.Equal(t, "CY", lookup(t, c, "1.1.1.1"))
+	assert.Equal(t, "US", lookup(t, c, "3.3.3.3"))
+	assert.Equal(t, 1, next.Lookups("1.1.1.1"))
+	assert.Equal(t, 1, next.Lookups("3.3.3.3"))
+
+	assert.Equal(t, "GR", lookup(t, c, "2.2.2.2"))
+	assert.Equal(t, 2, next.Lookups("2.2.2.2"))
+
+	assert.Equal(t, IpCheckerCacheStats{Hits: 3, Misses: 4}, c.Stats())
+}
+
+func TestCachedIpChecker_TTL(t *testing.T) {
+	next := &stubLocations{locations: map[string]string{"1.1.1.1": "CY"}}
+	c, clock := newTestCache(next, 10)
+
+	assert.Equal(t, "CY", lookup(t, c, "1.1.1.1"))
+	clock.Advance(time.Hour)
+	assert.Equal(t, "CY", lookup(t, c, "1.1.1.1"))
+	assert.Equal(t, 1, next.Lookups("1.1.1.1"))
+
+	clock.Advance(time.Second)
+	assert.Equal(t, "CY", lookup(t, c, "1.1.1.1"))
+	assert.Equal(t, 2, next.Lookups("1.1.1.1"))
+}
+
+func TestCachedIpChecker_NegativeTTL(t *testing.T) {
+	next := &stubLocations{}
+	c, clock := newTestCache(next, 10)
+
+	for i := 0; i < 2; i++ {
+		_, err := c.GetUserLocation(context.Background(), "1.1.1.1")
+		assert.ErrorIs(t, err, ierr.UnknownLocation)
+	}
+	assert.Equal(t, 1, next.Lookups("1.1.1.1"))
+	assert.Equal(t, IpCheckerCacheStats{NegativeHits: 1, Misses: 1}, c.Stats())
+
+	clock.Advance(time.Minute*5 + time.Second)
+	_, err := c.GetUserLocation(context.Background(), "1.1.1.1")
+	assert.ErrorIs(t, err, ierr.UnknownLocation)
+	assert.Equal(t, 2, next.Lookups("1.1.1.1"))
+}
+
+func TestCachedIpChecker_ErrorsAreNotCached(t *testing.T) {
+	failure := errors.New("provider is down")
+	var calls uint64
+	next := NewIpCheckerMock(t).GetUserLocationMock.Set(func(_ context.Context, _ string) (string, error) {
+		atomic.AddUint64(&calls, 1)
+		return "", failure
+	})
+	c, _ := newTestCache(next, 10)
+
+	for i := 0; i < 2; i++ {
+		_, err := c.GetUserLocation(context.Background(), "1.1.1.1")
+		assert.ErrorIs(t, err, failure)
+	}
+	assert.EqualValues(t, 2, atomic.LoadUint64(&calls))
+}
+
+func TestCachedIpChecker_Singleflight(t *testing.T) {
+	const callers = 10
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var calls uint64
+	next := NewIpCheckerMock(t).GetUserLocationMock.Set(func(_ context.Context, _ string) (string, error) {
+		if atomic.AddUint64(&calls, 1) == 1 {
+			close(started)
+		}
+		<-release
+		return "CY", nil
+	})
+	c, _ := newTestCache(next, 10)
+
+	var wg sync.WaitGroup
+	locations := make([]string, callers)
+	for i := 0; i < callers; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			locations[i], _ = c.GetUserLocation(context.Background(), "1.1.1.1")
+		}()
+	}
+
+	<-started
+	// every caller missed the cache, give them time to join the lookup in flight
+	require.Eventually(t, func() bool { return c.Stats().Misses == callers }, time.Second, time.Millisecond)
+	time.Sleep(time.Millisecond * 10)
+	close(release)
+	wg.Wait()
+
+	assert.EqualValues(t, 1, atomic.LoadUint64(&calls))
+	for _, location := range locations {
+		assert.Equal(t, "CY", location)
+	}
+}
+
+func TestCachedIpChecker_CallerCancelled(t *testing.T) {
+	release := make(chan struct{})
+	next := NewIpCheckerMock(t).GetUserLocationMock.Set(func(ctx context.Context, _ string) (string, error) {
+		<-release
+		// the lookup isn't cancelled with the caller
+		return "CY", ctx.Err()
+	})
+	c, _ := newTestCache(next, 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := c.GetUserLocation(ctx, "1.1.1.1")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	close(release)
+	require.Eventually(t, func() bool {
+		_, ok := c.get("1.1.1.1")
+		return ok
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, "CY", lookup(t, c, "1.1.1.1"))
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	ierr "github.com/IakimenkoD/xm-companies-service/internal/errors"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func stubIpChecker(t *testing.T, location string, err error) *IpCheckerMock {
+	return NewIpCheckerMock(t).GetUserLocationMock.Return(location, err)
+}
+
+func TestIpCheckerChain_GetUserLocation(t *testing.T) {
+	failure := errors.New("provider is down")
+
+	tt := []struct {
+		name      string
+		providers func(t *testing.T) []IpChecker
+		location  string
+		err       error
+	}{
+		{
+			name: "first provider resolves",
+			providers: func(t *testing.T) []IpChecker {
+				// the second provider isn't asked, the mock fails the test otherwise
+				return []IpChecker{stubIpChecker(t, "CY", nil), NewIpCheckerMock(t)}
+			},
+			location: "CY",
+		},
+		{
+			name: "falls through on unknown location",
+			providers: func(t *testing.T) []IpChecker {
+				return []IpChecker{stubIpChecker(t, "", ierr.UnknownLocation), stubIpChecker(t, "GR", nil)}
+			},
+			location: "GR",
+		},
+		{
+			name: "falls through on error",
+			providers: func(t *testing.T) []IpChecker {
+				return []IpChecker{stubIpChecker(t, "", failure), stubIpChecker(t, "GR", nil)}
+			},
+			location: "GR",
+		},
+		{
+			name: "fail: nobody knows location",
+			providers: func(t *testing.T) []IpChecker {
+				return []IpChecker{stubIpChecker(t, "", ierr.UnknownLocation), stubIpChecker(t, "", ierr.UnknownLocation)}
+			},
+			err: ierr.UnknownLocation,
+		},
+		{
+			name: "fail: error is preferred to unknown location",
+			providers: func(t *testing.T) []IpChecker {
+				return []IpChecker{stubIpChecker(t, "", failure), stubIpChecker(t, "", ierr.UnknownLocation)}
+			},
+			err: failure,
+		},
+		{
+			name: "fail: no providers",
+			providers: func(t *testing.T) []IpChecker {
+				return nil
+			},
+			err: ierr.UnknownLocation,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			chain := NewIpCheckerChain(zap.NewNop(), tc.providers(t)...)
+
+			location, err := chain.GetUserLocation(context.Background(), "1.1.1.1")
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.location, location)
+		})
+	}
+}
@@ -1,26 +1,62 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/IakimenkoD/xm-companies-service/internal/config"
+	"github.com/IakimenkoD/xm-companies-service/internal/metrics"
 	"github.com/IakimenkoD/xm-companies-service/internal/model"
+	"github.com/IakimenkoD/xm-companies-service/internal/tracing"
 	"github.com/pkg/errors"
 	"github.com/streadway/amqp"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"strings"
 	"time"
 )
 
+// EventVersion is a version of EventEnvelope format, it's bumped on every breaking change.
+const EventVersion = 1
+
 //go:generate minimock -i MessageQueue -g -o mq_mock.go
 type MessageQueue interface {
-	NotifyCompanyUpdated(company *model.Company) error
+	NotifyCompanyChanged(ctx context.Context, event *model.CompanyEvent) error
+	// NotifyCompaniesChanged publishes all events of the batch in a single message.
+	NotifyCompaniesChanged(ctx context.Context, batch *model.CompanyBatchEvent) error
+	// StatusCheck fails while there is no connection to the broker.
+	StatusCheck(ctx context.Context) error
 }
 
 type messageQueue struct {
-	channel *amqp.Channel
-	queue   amqp.Queue
-	log     *zap.Logger
+	conn     *mqConnection
+	exchange string
+	log      *zap.Logger
+}
+
+// EventEnvelope is a message published for every created, updated, deleted or restored company.
+type EventEnvelope struct {
+	Version    int                          `json:"version"`
+	EventID    string                       `json:"event_id"`
+	Type       string                       `json:"type"`
+	OccurredAt time.Time                    `json:"occurred_at"`
+	Actor      string                       `json:"actor,omitempty"`
+	Company    NotificationTask             `json:"company"`
+	Changes    map[string]model.FieldChange `json:"changes,omitempty"`
 }
 
+// BatchEnvelope is a message published for every batch operation, Operation is a type of its events.
+type BatchEnvelope struct {
+	Version    int             `json:"version"`
+	EventID    string          `json:"event_id"`
+	Type       string          `json:"type"`
+	Operation  string          `json:"operation"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Actor      string          `json:"actor,omitempty"`
+	Events     []EventEnvelope `json:"events"`
+}
+
+// NotificationTask is a snapshot of the company after the change.
 type NotificationTask struct {
 	CompanyID int64      `json:"company_id"`
 	UpdatedAt *time.Time `json:"updated_at"`
@@ -32,57 +68,182 @@ type NotificationTask struct {
 	NewPhone   *string `json:"new_phone,omitempty"`
 }
 
-func (m messageQueue) NotifyCompanyUpdated(company *model.Company) error {
-	task := NotificationTask{
-		CompanyID:  company.ID,
-		NewName:    &company.Name,
-		NewCode:    &company.Code,
-		NewCountry: &company.Country,
-		NewWebsite: &company.Website,
-		NewPhone:   &company.Phone,
-	}
-
-	taskBytes, err := json.Marshal(task)
+func (m messageQueue) NotifyCompanyChanged(ctx context.Context, event *model.CompanyEvent) error {
+	taskBytes, err := json.Marshal(newEventEnvelope(event))
 	if err != nil {
 		return errors.Wrap(err, "marshalling notification task")
 	}
 	m.log.Debug("publishing notification event to mq", zap.ByteString("event", taskBytes))
 
-	return m.channel.Publish(
-		"",
-		m.queue.Name,
-		false,
-		false,
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        taskBytes,
-		})
+	// countries are stored lower case, routing keys carry them as upper case ISO codes
+	key := routingKey(event.Type, strings.ToUpper(event.Company.Country))
+	return m.publish(ctx, key, event.ID, event.Type, event.OccurredAt, taskBytes)
 }
 
-func NewMessageQueue(cfg *config.Config, log *zap.Logger) (MessageQueue, error) {
-	conn, err := amqp.Dial(cfg.MQ.Address)
-	if err != nil {
-		return nil, err
+func (m messageQueue) NotifyCompaniesChanged(ctx context.Context, batch *model.CompanyBatchEvent) error {
+	envelope := BatchEnvelope{
+		Version:    EventVersion,
+		EventID:    batch.ID,
+		Type:       model.EventCompanyBatch,
+		Operation:  batch.Operation,
+		OccurredAt: batch.OccurredAt,
+		Actor:      batch.Actor,
+		Events:     make([]EventEnvelope, 0, len(batch.Events)),
+	}
+	for _, event := range batch.Events {
+		envelope.Events = append(envelope.Events, newEventEnvelope(event))
 	}
-	amqpChannel, err := conn.Channel()
+
+	taskBytes, err := json.Marshal(envelope)
 	if err != nil {
-		return nil, err
+		return errors.Wrap(err, "marshalling notification batch")
 	}
+	m.log.Debug("publishing notification batch to mq",
+		zap.String("event_id", batch.ID),
+		zap.Int("events", len(batch.Events)))
+
+	key := routingKey(model.EventCompanyBatch, strings.TrimPrefix(batch.Operation, "company."))
+	return m.publish(ctx, key, batch.ID, model.EventCompanyBatch, batch.OccurredAt, taskBytes)
+}
+
+func (m messageQueue) StatusCheck(ctx context.Context) error {
+	return m.conn.StatusCheck(ctx)
+}
 
-	queue, err := amqpChannel.QueueDeclare(
-		cfg.MQ.Queue,
-		false,
-		false,
-		false,
-		false,
-		nil,
+// publish sends persistent message to the exchange with trace context of ctx in its headers.
+func (m messageQueue) publish(ctx context.Context, key, id, eventType string, occurredAt time.Time, body []byte) (err error) {
+	ctx, span := tracing.Start(ctx, m.exchange+" send",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("rabbitmq"),
+			semconv.MessagingDestinationKey.String(m.exchange),
+			semconv.MessagingRabbitmqRoutingKeyKey.String(key),
+			semconv.MessagingMessageIDKey.String(id),
+		),
 	)
+	defer func() { tracing.End(span, err) }()
+
+	headers := amqp.Table{}
+	tracing.Inject(ctx, tableCarrier(headers))
+
+	err = m.conn.publish(ctx, m.exchange, key, amqp.Publishing{
+		Headers:      headers,
+		DeliveryMode: amqp.Persistent,
+		ContentType:  "application/json",
+		MessageId:    id,
+		Type:         eventType,
+		Timestamp:    occurredAt,
+		Body:         body,
+	})
+
+	result := metrics.ResultSuccess
 	if err != nil {
-		return nil, err
+		result = metrics.ResultFailure
+	}
+	metrics.MQPublished.WithLabelValues(eventType, result).Inc()
+
+	return err
+}
+
+// routingKey appends word to event type, dots of the word are replaced as they separate words of the key.
+func routingKey(eventType, word string) string {
+	if word == "" {
+		word = "unknown"
+	}
+	return eventType + "." + strings.ReplaceAll(word, ".", "_")
+}
+
+// tableCarrier adapts AMQP headers for trace context propagation.
+type tableCarrier amqp.Table
+
+func (c tableCarrier) Get(key string) string {
+	value, _ := c[key].(string)
+	return value
+}
+
+func (c tableCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+func (c tableCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func newEventEnvelope(event *model.CompanyEvent) EventEnvelope {
+	company := event.Company
+	updatedAt := company.UpdatedAt
+	if updatedAt == nil {
+		updatedAt = &company.CreatedAt
+	}
+
+	return EventEnvelope{
+		Version:    EventVersion,
+		EventID:    event.ID,
+		Type:       event.Type,
+		OccurredAt: event.OccurredAt,
+		Actor:      event.Actor,
+		Company: NotificationTask{
+			CompanyID:  company.ID,
+			UpdatedAt:  updatedAt,
+			NewName:    &company.Name,
+			NewCode:    &company.Code,
+			NewCountry: &company.Country,
+			NewWebsite: &company.Website,
+			NewPhone:   &company.Phone,
+		},
+		Changes: event.Changes,
+	}
+}
+
+// NewMessageQueue connects to the broker in background until ctx is cancelled, topology described
+// by config.MessageQueue is declared on every connection. Messages published while there is no
+// connection fail.
+func NewMessageQueue(ctx context.Context, cfg *config.Config, log *zap.Logger) MessageQueue {
+	m := &messageQueue{
+		conn:     newMQConnection(cfg.MQ, declareTopology(cfg.MQ), log),
+		exchange: cfg.MQ.Exchange,
+		log:      log,
+	}
+	go m.conn.run(ctx)
+
+	return m
+}
+
+func declareTopology(cfg config.MessageQueue) func(ch *amqp.Channel) error {
+	return func(ch *amqp.Channel) error {
+		if err := ch.ExchangeDeclare(cfg.Exchange, cfg.ExchangeType, true, false, false, false, nil); err != nil {
+			return errors.Wrapf(err, "declaring exchange %s", cfg.Exchange)
+		}
+		if cfg.Queue == "" {
+			return nil
+		}
+
+		args := amqp.Table{}
+		if cfg.DeadLetterExchange != "" {
+			if err := ch.ExchangeDeclare(cfg.DeadLetterExchange, amqp.ExchangeFanout, true, false, false, false, nil); err != nil {
+				return errors.Wrapf(err, "declaring dead letter exchange %s", cfg.DeadLetterExchange)
+			}
+			if _, err := ch.QueueDeclare(cfg.DeadLetterQueue, true, false, false, false, nil); err != nil {
+				return errors.Wrapf(err, "declaring dead letter queue %s", cfg.DeadLetterQueue)
+			}
+			if err := ch.QueueBind(cfg.DeadLetterQueue, "", cfg.DeadLetterExchange, false, nil); err != nil {
+				return errors.Wrapf(err, "binding dead letter queue %s", cfg.DeadLetterQueue)
+			}
+			args["x-dead-letter-exchange"] = cfg.DeadLetterExchange
+		}
+
+		if _, err := ch.QueueDeclare(cfg.Queue, true, false, false, false, args); err != nil {
+			return errors.Wrapf(err, "declaring queue %s", cfg.Queue)
+		}
+		for _, key := range cfg.BindingKeys {
+			if err := ch.QueueBind(cfg.Queue, key, cfg.Exchange, false, nil); err != nil {
+				return errors.Wrapf(err, "binding queue %s by %s", cfg.Queue, key)
+			}
+		}
+		return nil
 	}
-	return &messageQueue{
-		channel: amqpChannel,
-		queue:   queue,
-		log:     log,
-	}, nil
 }
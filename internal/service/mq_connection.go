@@ -0,0 +1,226 @@
+package service
+
+import (
+	"context"
+	"github.com/IakimenkoD/xm-companies-service/internal/config"
+	"github.com/IakimenkoD/xm-companies-service/internal/metrics"
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// confirmsBuffer keeps late confirmations of timed out publishes, so that they don't block the connection.
+const confirmsBuffer = 16
+
+// mqChannel publishes messages, it's *amqp.Channel in confirm mode replaced by tests.
+type mqChannel interface {
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+// mqConnection keeps connection and channel to the broker open, they are reopened with backoff
+// once closed. setup declares topology of the channel on every connection.
+type mqConnection struct {
+	cfg   config.MessageQueue
+	setup func(ch *amqp.Channel) error
+	log   *zap.Logger
+
+	// mu guards the fields below, it's held during publish until the message is confirmed.
+	mu       sync.Mutex
+	conn     *amqp.Connection
+	channel  mqChannel
+	confirms <-chan amqp.Confirmation
+	// tag is a delivery tag of the last message published to the channel.
+	tag uint64
+
+	// errMu guards err only, so that status is checked without waiting for publish.
+	errMu sync.RWMutex
+	// err is a reason of missing connection.
+	err error
+}
+
+func newMQConnection(cfg config.MessageQueue, setup func(ch *amqp.Channel) error, log *zap.Logger) *mqConnection {
+	return &mqConnection{
+		cfg:   cfg,
+		setup: setup,
+		log:   log,
+		err:   errors.New("not connected yet"),
+	}
+}
+
+// run connects to the broker and reconnects after connection loss until ctx is cancelled.
+func (c *mqConnection) run(ctx context.Context) {
+	defer c.close()
+
+	for attempts := 0; ; attempts++ {
+		if attempts > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(c.backoff(attempts - 1)):
+			}
+			metrics.MQReconnects.Inc()
+		}
+
+		closed, err := c.connect()
+		if err != nil {
+			c.log.Warn("connecting to message queue", zap.Int("attempt", attempts+1), zap.Error(err))
+			c.disconnected(err)
+			continue
+		}
+		c.log.Info("connected to message queue")
+		attempts = 0
+
+		select {
+		case <-ctx.Done():
+			return
+		case err = <-closed:
+			c.log.Warn("message queue connection lost", zap.Error(err))
+			c.disconnected(err)
+		}
+	}
+}
+
+// connect opens connection and channel in confirm mode, the returned channel gets
+// the reason of either of them being closed.
+func (c *mqConnection) connect() (<-chan error, error) {
+	conn, err := amqp.Dial(c.cfg.Address)
+	if err != nil {
+		return nil, errors.Wrap(err, "dialing")
+	}
+
+	channel, err := conn.Channel()
+	if err == nil {
+		err = errors.Wrap(channel.Confirm(false), "enabling publisher confirms")
+	}
+	if err == nil {
+		err = errors.Wrap(c.setup(channel), "declaring topology")
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	channelClosed := channel.NotifyClose(make(chan *amqp.Error, 1))
+	closed := make(chan error, 1)
+	go func() {
+		var reason *amqp.Error
+		select {
+		case reason = <-connClosed:
+		case reason = <-channelClosed:
+		}
+		// channel alone can't be recovered by closing it, so connection is reopened as well
+		_ = conn.Close()
+
+		if reason == nil {
+			closed <- errors.New("closed")
+			return
+		}
+		closed <- reason
+	}()
+
+	c.mu.Lock()
+	c.conn = conn
+	c.channel = channel
+	c.confirms = channel.NotifyPublish(make(chan amqp.Confirmation, confirmsBuffer))
+	c.tag = 0
+	c.mu.Unlock()
+	c.setErr(nil)
+	metrics.MQConnected.Set(1)
+
+	return closed, nil
+}
+
+func (c *mqConnection) disconnected(err error) {
+	// the reason is set first, so that publish never sees missing channel without it
+	c.setErr(err)
+	c.mu.Lock()
+	c.conn = nil
+	c.channel = nil
+	c.confirms = nil
+	c.mu.Unlock()
+	metrics.MQConnected.Set(0)
+}
+
+func (c *mqConnection) setErr(err error) {
+	c.errMu.Lock()
+	c.err = err
+	c.errMu.Unlock()
+}
+
+func (c *mqConnection) lastErr() error {
+	c.errMu.RLock()
+	defer c.errMu.RUnlock()
+	return c.err
+}
+
+func (c *mqConnection) close() {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn != nil {
+		_ = conn.Close()
+	}
+	c.disconnected(errors.New("closed"))
+}
+
+// publish sends the message and waits until the broker confirms it. Message which confirmation
+// timed out may still be delivered, so it may be delivered twice if it's published again.
+func (c *mqConnection) publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.channel == nil {
+		return errors.Wrap(c.lastErr(), "message queue is not connected")
+	}
+
+	if err := c.channel.Publish(exchange, key, false, false, msg); err != nil {
+		return errors.Wrap(err, "publishing")
+	}
+	c.tag++
+
+	timer := time.NewTimer(c.cfg.ConfirmTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case confirm, ok := <-c.confirms:
+			switch {
+			case !ok:
+				return errors.New("channel closed before confirmation")
+			case confirm.DeliveryTag < c.tag:
+				// confirmation of earlier timed out message
+				continue
+			case !confirm.Ack:
+				return errors.New("message is rejected by broker")
+			}
+			return nil
+		case <-timer.C:
+			return errors.New("confirmation timed out")
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// StatusCheck returns reason of missing connection, it doesn't wait for messages being published.
+func (c *mqConnection) StatusCheck(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.lastErr()
+}
+
+// backoff doubles delay for every failed attempt up to ReconnectMaxBackoff.
+func (c *mqConnection) backoff(attempts int) time.Duration {
+	delay := c.cfg.ReconnectMinBackoff
+	for i := 0; i < attempts && delay < c.cfg.ReconnectMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > c.cfg.ReconnectMaxBackoff {
+		delay = c.cfg.ReconnectMaxBackoff
+	}
+
+	return delay
+}
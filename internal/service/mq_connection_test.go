@@ -0,0 +1,183 @@
+package service
+
+import (
+	"context"
+	"github.com/IakimenkoD/xm-companies-service/internal/config"
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+// stubChannel records published messages and their routing keys, err fails publishing.
+// sent is notified of every message if set.
+type stubChannel struct {
+	published []amqp.Publishing
+	keys      []string
+	err       error
+	sent      chan struct{}
+}
+
+func (ch *stubChannel) Publish(_, key string, _, _ bool, msg amqp.Publishing) error {
+	if ch.err != nil {
+		return ch.err
+	}
+	ch.published = append(ch.published, msg)
+	ch.keys = append(ch.keys, key)
+	if ch.sent != nil {
+		ch.sent <- struct{}{}
+	}
+	return nil
+}
+
+// newStubConnection returns connection to stub channel which confirmations are sent to the returned channel.
+func newStubConnection(ch mqChannel) (*mqConnection, chan amqp.Confirmation) {
+	confirms := make(chan amqp.Confirmation, confirmsBuffer)
+	c := newMQConnection(config.MessageQueue{ConfirmTimeout: time.Millisecond * 50}, nil, zap.NewNop())
+	c.channel = ch
+	c.confirms = confirms
+	c.setErr(nil)
+	return c, confirms
+}
+
+func TestMQConnection_Backoff(t *testing.T) {
+	c := newMQConnection(config.MessageQueue{
+		ReconnectMinBackoff: time.Millisecond * 500,
+		ReconnectMaxBackoff: time.Second * 3,
+	}, nil, zap.NewNop())
+
+	tt := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{attempts: 0, expected: time.Millisecond * 500},
+		{attempts: 1, expected: time.Second},
+		{attempts: 2, expected: time.Second * 2},
+		{attempts: 3, expected: time.Second * 3},
+		{attempts: 100, expected: time.Second * 3},
+	}
+	for _, tc := range tt {
+		assert.Equal(t, tc.expected, c.backoff(tc.attempts), "attempts %d", tc.attempts)
+	}
+}
+
+func TestMQConnection_Publish(t *testing.T) {
+	tt := []struct {
+		name     string
+		confirms []amqp.Confirmation
+		// tag of the last message published before
+		tag     uint64
+		closed  bool
+		wantErr string
+	}{
+		{
+			name:     "ack",
+			confirms: []amqp.Confirmation{{DeliveryTag: 1, Ack: true}},
+		},
+		{
+			name:     "late confirmation of timed out message is skipped",
+			tag:      1,
+			confirms: []amqp.Confirmation{{DeliveryTag: 1, Ack: false}, {DeliveryTag: 2, Ack: true}},
+		},
+		{
+			name:     "fail: nack",
+			confirms: []amqp.Confirmation{{DeliveryTag: 1, Ack: false}},
+			wantErr:  "message is rejected by broker",
+		},
+		{
+			name:     "fail: only late confirmation of earlier message arrives",
+			tag:      1,
+			confirms: []amqp.Confirmation{{DeliveryTag: 1, Ack: true}},
+			wantErr:  "confirmation timed out",
+		},
+		{
+			name:    "fail: timeout",
+			wantErr: "confirmation timed out",
+		},
+		{
+			name:    "fail: channel closed",
+			closed:  true,
+			wantErr: "channel closed before confirmation",
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			ch := &stubChannel{}
+			c, confirms := newStubConnection(ch)
+			c.tag = tc.tag
+			for _, confirm := range tc.confirms {
+				confirms <- confirm
+			}
+			if tc.closed {
+				close(confirms)
+			}
+
+			err := c.publish(context.Background(), "companies", "company.created.CY", amqp.Publishing{Body: []byte("{}")})
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Len(t, ch.published, 1)
+			assert.Equal(t, tc.tag+1, c.tag)
+		})
+	}
+}
+
+func TestMQConnection_PublishFailures(t *testing.T) {
+	t.Run("not connected", func(t *testing.T) {
+		c := newMQConnection(config.MessageQueue{}, nil, zap.NewNop())
+		c.disconnected(errors.New("dial tcp: connection refused"))
+
+		err := c.publish(context.Background(), "companies", "key", amqp.Publishing{})
+		assert.EqualError(t, err, "message queue is not connected: dial tcp: connection refused")
+	})
+
+	t.Run("publish error", func(t *testing.T) {
+		c, _ := newStubConnection(&stubChannel{err: amqp.ErrClosed})
+
+		err := c.publish(context.Background(), "companies", "key", amqp.Publishing{})
+		assert.ErrorIs(t, err, amqp.ErrClosed)
+		assert.EqualValues(t, 0, c.tag)
+	})
+
+	t.Run("context cancelled", func(t *testing.T) {
+		c, _ := newStubConnection(&stubChannel{})
+		c.cfg.ConfirmTimeout = time.Minute
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := c.publish(ctx, "companies", "key", amqp.Publishing{})
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestMQConnection_StatusCheckDoesNotWaitForPublish(t *testing.T) {
+	ch := &stubChannel{sent: make(chan struct{}, 1)}
+	c, confirms := newStubConnection(ch)
+	c.cfg.ConfirmTimeout = time.Minute
+
+	published := make(chan error)
+	go func() {
+		published <- c.publish(context.Background(), "companies", "key", amqp.Publishing{})
+	}()
+	// publish holds the connection lock until the message is confirmed
+	<-ch.sent
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, c.StatusCheck(ctx))
+
+	c.setErr(errors.New("closed"))
+	assert.EqualError(t, c.StatusCheck(ctx), "closed")
+
+	confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: true}
+	assert.NoError(t, <-published)
+
+	cancel()
+	assert.ErrorIs(t, c.StatusCheck(ctx), context.Canceled)
+}
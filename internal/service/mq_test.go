@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"github.com/IakimenkoD/xm-companies-service/internal/model"
+	"github.com/IakimenkoD/xm-companies-service/internal/tracing"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func TestRoutingKey(t *testing.T) {
+	testCases := []struct {
+		name      string
+		eventType string
+		word      string
+		want      string
+	}{
+		{
+			name:      "country",
+			eventType: model.EventCompanyCreated,
+			word:      "CY",
+			want:      "company.created.CY",
+		},
+		{
+			name:      "empty word",
+			eventType: model.EventCompanyDeleted,
+			want:      "company.deleted.unknown",
+		},
+		{
+			name:      "dots are replaced",
+			eventType: model.EventCompanyBatch,
+			word:      "a.b",
+			want:      "company.batch.a_b",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, routingKey(tc.eventType, tc.word))
+		})
+	}
+}
+
+func TestMessageQueue_NotifyCompanyChanged(t *testing.T) {
+	testCases := []struct {
+		name    string
+		country string
+		wantKey string
+	}{
+		{
+			name:    "upper case country",
+			country: "CY",
+			wantKey: "company.updated.CY",
+		},
+		{
+			name:    "lower case country is uppercased",
+			country: "cy",
+			wantKey: "company.updated.CY",
+		},
+		{
+			name:    "missing country",
+			wantKey: "company.updated.unknown",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			ch := &stubChannel{}
+			conn, confirms := newStubConnection(ch)
+			confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: true}
+			mq := messageQueue{conn: conn, exchange: "companies", log: zap.NewNop()}
+
+			err := mq.NotifyCompanyChanged(context.Background(), &model.CompanyEvent{
+				ID:      "event-1",
+				Type:    model.EventCompanyUpdated,
+				Company: &model.Company{ID: 1, Country: tc.country},
+			})
+			require.NoError(t, err)
+			assert.Equal(t, []string{tc.wantKey}, ch.keys)
+		})
+	}
+}
+
+func TestMessageQueue_InjectsTraceContext(t *testing.T) {
+	const (
+		changeTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		changeSpanID  = "00f067aa0ba902b7"
+	)
+	recorder := tracing.Record()
+
+	// trace context as the relay extracts it from outbox message headers
+	ctx := tracing.Extract(context.Background(), propagation.MapCarrier{
+		"traceparent": "00-" + changeTraceID + "-" + changeSpanID + "-01",
+	})
+
+	ch := &stubChannel{}
+	conn, confirms := newStubConnection(ch)
+	confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: true}
+	mq := messageQueue{conn: conn, exchange: "companies", log: zap.NewNop()}
+
+	err := mq.NotifyCompanyChanged(ctx, &model.CompanyEvent{
+		ID:      "event-1",
+		Type:    model.EventCompanyUpdated,
+		Company: &model.Company{ID: 1, Country: "cy"},
+	})
+	require.NoError(t, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	send := spans[0]
+	assert.Equal(t, "companies send", send.Name())
+	assert.Equal(t, trace.SpanKindProducer, send.SpanKind())
+	assert.Equal(t, changeTraceID, send.SpanContext().TraceID().String())
+	assert.Equal(t, changeSpanID, send.Parent().SpanID().String())
+
+	require.Len(t, ch.published, 1)
+	assert.Equal(t, "00-"+changeTraceID+"-"+send.SpanContext().SpanID().String()+"-01",
+		ch.published[0].Headers["traceparent"], "consumers continue trace of the change")
+}